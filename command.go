@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/config"
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+// command runs a one-off command instead of starting the server
+func command(o internal.Options, args []string) error {
+	switch args[0] {
+	case "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: curt [flags] restore <backup file | ->")
+		}
+		return restore(o, args[1])
+	case "rotate-key":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: curt [flags] rotate-key <new key file>")
+		}
+		return rotateKey(o, args[1])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// configCommand runs before the logger is set up, so that its output is
+// nothing but the config
+func configCommand(c config.Config, args []string) error {
+	if len(args) != 2 || args[1] != "print" {
+		return fmt.Errorf("usage: curt [flags] config print")
+	}
+	return config.Print(os.Stdout, c)
+}
+
+// hashKeyCommand prints the hash of the API key read from stdin, so that
+// the key never shows up in the process list
+func hashKeyCommand(args []string) error {
+	algorithm := "argon2id"
+	if len(args) == 2 {
+		algorithm = args[1]
+	} else if len(args) > 2 {
+		return fmt.Errorf("usage: curt hash-key [argon2id | sha256] < key")
+	}
+	if algorithm != "argon2id" && algorithm != "sha256" {
+		return fmt.Errorf("unknown hash: %s, must be argon2id or sha256", algorithm)
+	}
+
+	line, e := bufio.NewReader(os.Stdin).ReadString('\n')
+	if e != nil && e != io.EOF {
+		return e
+	}
+	key := strings.TrimRight(line, "\r\n")
+	if key == "" {
+		return fmt.Errorf("empty key, write it to stdin")
+	}
+
+	switch algorithm {
+	case "argon2id":
+		hash, e := middlewares.HashAPIKey(key)
+		if e != nil {
+			return e
+		}
+		fmt.Println(hash)
+	case "sha256":
+		digest := sha256.Sum256([]byte(key))
+		fmt.Println("sha256:" + hex.EncodeToString(digest[:]))
+	}
+	return nil
+}
+
+// signTokenCommand prints a bearer token for subject, valid for an hour,
+// signed with a PEM private key, such as the half of a locally generated key
+// pair whose public key is in JWT_JWKS_FILE. The arguments after the subject
+// are roles, or claims given as name=value, e.g. workspace=marketing
+func signTokenCommand(c config.JWTConfig, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: curt [flags] sign-token <private key file> <subject> [role | claim=value ...]")
+	}
+
+	b, e := os.ReadFile(args[1])
+	if e != nil {
+		return e
+	}
+	key, e := parsePrivateKey(b)
+	if e != nil {
+		return e
+	}
+
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return fmt.Errorf("unsupported curve: %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("unsupported private key: %T", key)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		c.SubjectClaim: args[2],
+		"iat":          now.Unix(),
+		"exp":          now.Add(time.Hour).Unix(),
+	}
+	if c.Issuer != "" {
+		claims["iss"] = c.Issuer
+	}
+	if c.Audience != "" {
+		claims["aud"] = c.Audience
+	}
+	if len(c.RequiredScopes) > 0 && c.ScopesClaim != "" {
+		setClaim(claims, c.ScopesClaim, strings.Join(c.RequiredScopes, " "))
+	}
+	roles := []string{}
+	for _, arg := range args[3:] {
+		if name, value, found := strings.Cut(arg, "="); found {
+			setClaim(claims, name, value)
+		} else {
+			roles = append(roles, arg)
+		}
+	}
+	if len(roles) > 0 && c.RolesClaim != "" {
+		setClaim(claims, c.RolesClaim, roles)
+	}
+
+	token, e := jwt.NewWithClaims(method, claims).SignedString(key)
+	if e != nil {
+		return e
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// parsePrivateKey parses a PKCS #8, PKCS #1 or SEC 1 PEM private key
+func parsePrivateKey(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block: %s, must be a private key", block.Type)
+	}
+}
+
+// setClaim sets the claim at path, creating the nested objects on dots
+func setClaim(claims jwt.MapClaims, path string, value interface{}) {
+	names := strings.Split(path, ".")
+	object := map[string]interface{}(claims)
+	for _, name := range names[:len(names)-1] {
+		nested, ok := object[name].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			object[name] = nested
+		}
+		object = nested
+	}
+	object[names[len(names)-1]] = value
+}
+
+func restore(o internal.Options, path string) error {
+	var rd io.Reader = os.Stdin
+	if path != "-" {
+		f, e := os.Open(path)
+		if e != nil {
+			return e
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	// a one-off command has no use for the background jobs
+	o.GC.Interval = 0
+	o.Backup.Dir = ""
+
+	var r internal.Resolver
+	e := r.Create(o)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+
+	log.Info().Str("service", "CURT").Str("file", path).Msg("restoring backup")
+
+	e = r.Restore(rd)
+	if e != nil {
+		return e
+	}
+
+	e = r.AuditNow(models.AuditEntry{
+		Actor:  internal.AuditActorCLI,
+		Action: internal.AuditRestore,
+		After:  internal.AuditJSON(map[string]string{"file": path}),
+	})
+	if e != nil {
+		return e
+	}
+
+	log.Info().Str("service", "CURT").Str("file", path).Msg("backup restored")
+	return nil
+}
+
+func rotateKey(o internal.Options, path string) error {
+	key, e := internal.ReadEncryptionKey(path)
+	if e != nil {
+		return e
+	}
+
+	log.Info().Str("service", "CURT").Str("file", path).Msg("rotating encryption key")
+
+	e = internal.RotateEncryptionKey(o.Database, key)
+	if e != nil {
+		return e
+	}
+
+	// recorded with the new key, the rotation itself can't write to the database
+	o.Database.EncryptionKey = key
+	o.GC.Interval = 0
+	o.Backup.Dir = ""
+
+	var r internal.Resolver
+	e = r.Create(o)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+
+	e = r.AuditNow(models.AuditEntry{
+		Actor:  internal.AuditActorCLI,
+		Action: internal.AuditEncryptionKeyRotate,
+	})
+	if e != nil {
+		return e
+	}
+
+	log.Info().Str("service", "CURT").Msg("encryption key rotated, restart Curt with the new key")
+	return nil
+}
@@ -1,8 +1,3 @@
 #!/bin/bash
-params=()
-[[ $PORT ]] && params+=(-PORT $PORT)
-[[ $LOG_LEVEL ]] && params+=(-LOG_LEVEL $LOG_LEVEL)
-[[ $X_API_KEY ]] && params+=(-X_API_KEY $X_API_KEY)
-[[ $HOST ]] && params+=(-HOST $HOST)
-
-/app/curt ${params[@]}
\ No newline at end of file
+# every setting is read by curt itself from the environment, see the README
+exec /app/curt "$@"
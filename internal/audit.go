@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+// auditKeyPrefix namespaces the audit log, entries are keyed by their ID, a
+// timestamp in nanoseconds, so that they are sorted by time
+const auditKeyPrefix = internalKeyPrefix + "a/"
+
+// configKey holds the settings of the last start, to audit their changes
+const configKey = internalKeyPrefix + "config"
+
+const (
+	AuditLinkCreate          = "link.create"
+	AuditLinkUpdate          = "link.update"
+	AuditLinkDelete          = "link.delete"
+	AuditLinkMove            = "link.move"
+	AuditBackup              = "backup"
+	AuditRestore             = "restore"
+	AuditGC                  = "gc"
+	AuditBlocklistsReload    = "blocklists.reload"
+	AuditConfigChange        = "config.change"
+	AuditEncryptionKeyRotate = "encryption_key.rotate"
+)
+
+const (
+	// AuditActorSystem records the changes made by Curt itself
+	AuditActorSystem = "system"
+	// AuditActorCLI records the one-off commands
+	AuditActorCLI = "cli"
+)
+
+// AuditLink is the state of a link recorded in the audit log
+type AuditLink struct {
+	Url       string `json:"url"`
+	ExpiresAt uint64 `json:"expiresAt,omitempty"`
+	Flagged   bool   `json:"flagged,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// NewAuditLink records the link stored with url, expiresAt and meta, with
+// ownership o
+func NewAuditLink(url string, expiresAt uint64, meta byte, o Ownership) AuditLink {
+	return AuditLink{Url: url, ExpiresAt: expiresAt, Flagged: meta&MetaFlagged != 0, Owner: o.Owner, Workspace: o.Workspace}
+}
+
+// AuditJSON marshals v as the before or after value of an audit entry
+func AuditJSON(v interface{}) json.RawMessage {
+	b, e := json.Marshal(v)
+	if e != nil {
+		return nil
+	}
+	return b
+}
+
+// AuditQuery filters the audit log, empty fields match everything
+type AuditQuery struct {
+	Actor string
+	// Action matches itself and its sub-actions, link matches link.create
+	Action    string
+	Domain    string
+	Key       string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+	// Before is the ID the entries must be older than, to page through the log
+	Before uint64
+	Limit  int
+}
+
+func (q AuditQuery) match(e models.AuditEntry) bool {
+	return (q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Action == "" || e.Action == q.Action || strings.HasPrefix(e.Action, q.Action+".")) &&
+		(q.Domain == "" || e.Domain == q.Domain) &&
+		(q.Key == "" || e.Key == q.Key) &&
+		(q.RequestID == "" || e.RequestID == q.RequestID)
+}
+
+// bounds returns the range of IDs q matches
+func (q AuditQuery) bounds() (first uint64, last uint64) {
+	last = ^uint64(0)
+	if !q.Since.IsZero() {
+		first = uint64(q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		last = uint64(q.Until.UnixNano())
+	}
+	if q.Before > 0 && q.Before-1 < last {
+		last = q.Before - 1
+	}
+	return first, last
+}
+
+// ErrAuditIDTaken is returned when an audit entry would overwrite another
+var ErrAuditIDTaken = errors.New("the audit entry ID is already taken")
+
+func auditKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(auditKeyPrefix), id)
+}
+
+// seedAuditID starts the IDs after the last entry of the log, so that a
+// clock set back since doesn't reuse them
+func (r *Resolver) seedAuditID() error {
+	return r.BadgerDB.View(func(txn *badger.Txn) error {
+		o := badger.DefaultIteratorOptions
+		o.Prefix = []byte(auditKeyPrefix)
+		o.Reverse = true
+		o.PrefetchValues = false
+		it := txn.NewIterator(o)
+		defer it.Close()
+
+		it.Seek(auditKey(^uint64(0)))
+		if it.Valid() {
+			r.auditID.Store(binary.BigEndian.Uint64(it.Item().Key()[len(auditKeyPrefix):]))
+		}
+		return nil
+	})
+}
+
+// nextAuditID returns the current time in nanoseconds, or the last ID plus
+// one, so that IDs are unique and increasing
+func (r *Resolver) nextAuditID() uint64 {
+	for {
+		last := r.auditID.Load()
+		id := uint64(time.Now().UnixNano())
+		if id <= last {
+			id = last + 1
+		}
+		if r.auditID.CompareAndSwap(last, id) {
+			return id
+		}
+	}
+}
+
+// Audit appends entry to the audit log within txn, so that it is recorded
+// only if the change it describes is committed
+func (r *Resolver) Audit(txn *badger.Txn, entry models.AuditEntry) error {
+	id := r.nextAuditID()
+	entry.ID = strconv.FormatUint(id, 10)
+	entry.Time = time.Unix(0, int64(id)).UTC().Format(time.RFC3339Nano)
+
+	v, e := json.Marshal(entry)
+	if e != nil {
+		return e
+	}
+
+	// never overwrite an entry, whatever the clock did
+	_, e = txn.Get(auditKey(id))
+	switch e {
+	case nil:
+		return ErrAuditIDTaken
+	case badger.ErrKeyNotFound:
+	default:
+		return e
+	}
+	return txn.Set(auditKey(id), v)
+}
+
+// AuditNow appends entry to the audit log in a transaction of its own
+func (r *Resolver) AuditNow(entry models.AuditEntry) error {
+	return r.BadgerDB.Update(func(txn *badger.Txn) error {
+		return r.Audit(txn, entry)
+	})
+}
+
+// iterateAudit calls fn with the entries matching q, from the newest when
+// reverse is set, until fn returns false
+func (r *Resolver) iterateAudit(q AuditQuery, reverse bool, fn func(id uint64, entry models.AuditEntry) bool) error {
+	first, last := q.bounds()
+	if first > last {
+		return nil
+	}
+
+	return r.BadgerDB.View(func(txn *badger.Txn) error {
+		o := badger.DefaultIteratorOptions
+		o.Prefix = []byte(auditKeyPrefix)
+		o.Reverse = reverse
+		it := txn.NewIterator(o)
+		defer it.Close()
+
+		start := auditKey(first)
+		if reverse {
+			start = auditKey(last)
+		}
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			id := binary.BigEndian.Uint64(item.Key()[len(auditKeyPrefix):])
+			if id < first || id > last {
+				return nil
+			}
+
+			var entry models.AuditEntry
+			e := item.Value(func(v []byte) error {
+				return json.Unmarshal(v, &entry)
+			})
+			if e != nil {
+				return e
+			}
+
+			if q.match(entry) && !fn(id, entry) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// AuditLog returns up to q.Limit entries matching q, newest first, and the
+// Before of the next page, 0 on the last one
+func (r *Resolver) AuditLog(q AuditQuery) (entries []models.AuditEntry, next uint64, e error) {
+	entries = []models.AuditEntry{}
+	e = r.iterateAudit(q, true, func(id uint64, entry models.AuditEntry) bool {
+		if len(entries) == q.Limit {
+			next = id + 1
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	return entries, next, e
+}
+
+// ExportAudit calls fn with every entry matching q, oldest first, stopping
+// at the first error
+func (r *Resolver) ExportAudit(q AuditQuery, fn func(entry models.AuditEntry) error) (e error) {
+	iterateErr := r.iterateAudit(q, false, func(_ uint64, entry models.AuditEntry) bool {
+		e = fn(entry)
+		return e == nil
+	})
+	if e != nil {
+		return e
+	}
+	return iterateErr
+}
+
+// AuditConfigSecretChanged replaces the values of the secrets that changed
+const AuditConfigSecretChanged = "changed"
+
+// AuditConfig records the settings that changed since the last start, the
+// secrets are compared by their fingerprints and recorded as changed
+func (r *Resolver) AuditConfig(settings map[string]string, secrets map[string]bool) error {
+	settings = r.fingerprintSecrets(settings, secrets)
+	current, e := json.Marshal(settings)
+	if e != nil {
+		return e
+	}
+
+	return r.BadgerDB.Update(func(txn *badger.Txn) error {
+		previous := map[string]string{}
+		item, e := txn.Get([]byte(configKey))
+		switch e {
+		case nil:
+			e = item.Value(func(v []byte) error {
+				return json.Unmarshal(v, &previous)
+			})
+			if e != nil {
+				return e
+			}
+		case badger.ErrKeyNotFound:
+		default:
+			return e
+		}
+
+		before, after := map[string]string{}, map[string]string{}
+		for key, value := range settings {
+			if old, ok := previous[key]; !ok || old != value {
+				if secrets[key] {
+					value = AuditConfigSecretChanged
+				}
+				after[key] = value
+			}
+		}
+		for key, value := range previous {
+			if v, ok := settings[key]; (!ok || v != value) && !secrets[key] {
+				before[key] = value
+			}
+		}
+		if len(before) == 0 && len(after) == 0 {
+			return nil
+		}
+
+		entry := models.AuditEntry{
+			Actor:  AuditActorSystem,
+			Action: AuditConfigChange,
+		}
+		if len(before) > 0 {
+			entry.Before = AuditJSON(before)
+		}
+		if len(after) > 0 {
+			entry.After = AuditJSON(after)
+		}
+		e = r.Audit(txn, entry)
+		if e != nil {
+			return e
+		}
+		return txn.Set([]byte(configKey), current)
+	})
+}
+
+// fingerprintSecrets returns settings with the secrets set replaced by
+// their fingerprint, an HMAC under the fingerprint key, so that neither the
+// database nor its backups allow checking guesses of them
+func (r *Resolver) fingerprintSecrets(settings map[string]string, secrets map[string]bool) map[string]string {
+	fingerprinted := make(map[string]string, len(settings))
+	for key, value := range settings {
+		if secrets[key] && value != "" {
+			mac := hmac.New(sha256.New, r.fingerprintKey)
+			mac.Write([]byte(value))
+			value = "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+		}
+		fingerprinted[key] = value
+	}
+	return fingerprinted
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/salvatore-081/curt/internal/config"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+func TestAuditConfig(t *testing.T) {
+	r := newTestResolver(t, testOptions())
+	c := config.Default()
+	c.XAPIKey = "first-secret"
+	c.APIKeys = []string{"ci:ci-secret"}
+
+	// start audits the config and returns the latest config change
+	start := func() (id string, before, after map[string]string) {
+		t.Helper()
+		e := r.AuditConfig(config.Settings(c))
+		if e != nil {
+			t.Fatal(e)
+		}
+		entries, _, e := r.AuditLog(AuditQuery{Action: AuditConfigChange, Limit: 1})
+		if e != nil || len(entries) == 0 {
+			t.Fatalf("got %v, %v, want the config change", entries, e)
+		}
+		for _, v := range []json.RawMessage{entries[0].Before, entries[0].After} {
+			if strings.Contains(string(v), "secret") {
+				t.Fatalf("a secret was recorded: %s", v)
+			}
+		}
+		before, after = map[string]string{}, map[string]string{}
+		if entries[0].Before != nil {
+			json.Unmarshal(entries[0].Before, &before)
+		}
+		json.Unmarshal(entries[0].After, &after)
+		return entries[0].ID, before, after
+	}
+
+	id, _, after := start()
+	if after["x_api_key"] != AuditConfigSecretChanged || after["host"] != c.Host {
+		t.Errorf("first start recorded %v", after)
+	}
+
+	// nothing changed
+	if again, _, after := start(); again != id {
+		t.Errorf("an unchanged config was recorded: %v", after)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		before map[string]string
+		after  map[string]string
+	}{
+		{"secret rotated", func() { c.XAPIKey = "second-secret" }, map[string]string{}, map[string]string{"x_api_key": AuditConfigSecretChanged}},
+		{"secret in a list rotated", func() { c.APIKeys = []string{"ci:other-secret"} }, map[string]string{}, map[string]string{"api_keys": AuditConfigSecretChanged}},
+		{"secret removed", func() { c.XAPIKey = "" }, map[string]string{}, map[string]string{"x_api_key": AuditConfigSecretChanged}},
+		{"setting changed", func() { c.BasePath = "/curt" }, map[string]string{"base_path": ""}, map[string]string{"base_path": "/curt"}},
+	}
+	for _, tt := range tests {
+		tt.change()
+		_, before, after := start()
+		if !equalSettings(before, tt.before) || !equalSettings(after, tt.after) {
+			t.Errorf("%s: recorded %v -> %v, want %v -> %v", tt.name, before, after, tt.before, tt.after)
+		}
+	}
+
+	e := r.BadgerDB.View(func(txn *badger.Txn) error {
+		item, e := txn.Get([]byte(configKey))
+		if e != nil {
+			return e
+		}
+		return item.Value(func(v []byte) error {
+			if strings.Contains(string(v), "secret") {
+				t.Errorf("a secret was stored: %s", v)
+			}
+			return nil
+		})
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestAuditConfigAcrossRestarts(t *testing.T) {
+	o := testOptions()
+	o.Database.InMemory = false
+	o.Database.Dir = t.TempDir()
+	c := config.Default()
+	c.XAPIKey = "secret"
+
+	// start audits the config with a new resolver on the same database and
+	// returns the config changes recorded so far
+	start := func() []models.AuditEntry {
+		t.Helper()
+		r := &Resolver{}
+		e := r.Create(o)
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer r.Close()
+		e = r.AuditConfig(config.Settings(c))
+		if e != nil {
+			t.Fatal(e)
+		}
+		entries, _, e := r.AuditLog(AuditQuery{Action: AuditConfigChange, Limit: 10})
+		if e != nil {
+			t.Fatal(e)
+		}
+		return entries
+	}
+
+	if entries := start(); len(entries) != 1 {
+		t.Fatalf("got %d config changes on the first start, want 1", len(entries))
+	}
+	if entries := start(); len(entries) != 1 {
+		t.Errorf("got %d config changes after restarting with the same config, want 1", len(entries))
+	}
+
+	path := filepath.Join(o.Database.Dir, fingerprintKeyFile)
+	info, e := os.Stat(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("the fingerprint key is readable by others: %s", info.Mode())
+	}
+
+	// without the key, the fingerprint doesn't tell a guess of the secret
+	settings, secrets := map[string]string{"x_api_key": c.XAPIKey}, map[string]bool{"x_api_key": true}
+	one := newTestResolver(t, testOptions()).fingerprintSecrets(settings, secrets)
+	other := newTestResolver(t, testOptions()).fingerprintSecrets(settings, secrets)
+	hash := sha256.Sum256([]byte(c.XAPIKey))
+	if one["x_api_key"] == other["x_api_key"] || strings.Contains(one["x_api_key"], hex.EncodeToString(hash[:8])) {
+		t.Errorf("got the same fingerprint %s under other keys", one["x_api_key"])
+	}
+
+	// a database restored elsewhere gets a new key, the secrets show as
+	// changed once
+	e = os.Remove(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entries := start(); len(entries) != 2 {
+		t.Errorf("got %d config changes with a new fingerprint key, want 2", len(entries))
+	}
+}
+
+func TestAuditIDs(t *testing.T) {
+	o := testOptions()
+	o.Database.InMemory = false
+	o.Database.Dir = t.TempDir()
+	// an entry recorded before the clock was set back a day
+	ahead := uint64(time.Now().Add(24 * time.Hour).UnixNano())
+
+	r := &Resolver{}
+	e := r.Create(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = r.BadgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(auditKey(ahead), AuditJSON(models.AuditEntry{ID: strconv.FormatUint(ahead, 10), Action: AuditGC}))
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	r.Close()
+
+	r = newTestResolver(t, o)
+	e = r.AuditNow(models.AuditEntry{Actor: AuditActorSystem, Action: AuditBackup})
+	if e != nil {
+		t.Fatal(e)
+	}
+	entries, _, e := r.AuditLog(AuditQuery{Limit: 10})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 || entries[0].Action != AuditBackup || entries[0].ID != strconv.FormatUint(ahead+1, 10) || entries[1].Action != AuditGC {
+		t.Fatalf("got %+v, want the new entry after the one ahead of the clock", entries)
+	}
+
+	// taken IDs fail the change rather than overwrite its entry
+	r.auditID.Store(ahead - 1)
+	e = r.AuditNow(models.AuditEntry{Actor: AuditActorSystem, Action: AuditBackup})
+	if e != ErrAuditIDTaken {
+		t.Errorf("got %v reusing an ID, want ErrAuditIDTaken", e)
+	}
+	entries, _, _ = r.AuditLog(AuditQuery{Action: AuditGC, Limit: 10})
+	if len(entries) != 1 {
+		t.Errorf("got %+v, want the entry left alone", entries)
+	}
+}
+
+func equalSettings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	backupPrefix     = "curt-"
+	backupExtension  = ".bak"
+	backupTimeLayout = "20060102T150405Z"
+
+	// maxPendingWrites bounds the number of in-flight batches badger keeps
+	// while loading a backup
+	maxPendingWrites = 256
+)
+
+type BackupOptions struct {
+	// Dir is where scheduled backups are written, an empty Dir disables them
+	Dir       string
+	Interval  time.Duration
+	Retention int
+}
+
+func (o BackupOptions) validate() error {
+	if o.Dir == "" {
+		return nil
+	}
+	if o.Interval <= 0 {
+		return fmt.Errorf("invalid backup interval: %s, must be greater than 0", o.Interval)
+	}
+	if o.Retention < 0 {
+		return fmt.Errorf("invalid backup retention: %d, must be 0 (keep all) or greater", o.Retention)
+	}
+	return nil
+}
+
+// Backup writes a backup of every entry newer than since to w, it returns the
+// version to pass as since to take the next incremental backup. Badger
+// writes the entries decrypted, so the backups of an encrypted database are
+// encrypted again with its key
+func (r *Resolver) Backup(w io.Writer, since uint64) (uint64, error) {
+	if len(r.backupKey) == 0 {
+		return r.BadgerDB.Backup(w, since)
+	}
+
+	ew, e := newBackupEncrypter(w, r.backupKey)
+	if e != nil {
+		return 0, e
+	}
+	version, e := r.BadgerDB.Backup(ew, since)
+	if e != nil {
+		return 0, e
+	}
+	return version, ew.Close()
+}
+
+// Restore loads a backup produced by Backup into the database, badger
+// requires that no other transaction runs meanwhile, so it is only called
+// by the restore command, before anything else opens the database
+func (r *Resolver) Restore(rd io.Reader) error {
+	rd, e := decryptBackup(rd, r.backupKey)
+	if e != nil {
+		return e
+	}
+	e = r.BadgerDB.Load(rd, maxPendingWrites)
+	if e != nil {
+		return e
+	}
+	// backups made before workspaces existed hold links without ownership
+	return r.migrateOwnership()
+}
+
+// BackupToDir writes a full backup to a timestamped file in dir and returns its path
+func (r *Resolver) BackupToDir(dir string) (path string, e error) {
+	e = os.MkdirAll(dir, 0o750)
+	if e != nil {
+		return "", e
+	}
+
+	f, e := os.CreateTemp(dir, backupPrefix+"*.tmp")
+	if e != nil {
+		return "", e
+	}
+	defer func() {
+		if e != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	_, e = r.Backup(f, 0)
+	if e != nil {
+		return "", e
+	}
+
+	e = f.Sync()
+	if e != nil {
+		return "", e
+	}
+
+	e = f.Close()
+	if e != nil {
+		return "", e
+	}
+
+	path = filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupTimeLayout)+backupExtension)
+	e = os.Rename(f.Name(), path)
+	if e != nil {
+		return "", e
+	}
+
+	return path, nil
+}
+
+// pruneBackups removes the oldest backups in dir, keeping the newest retention ones
+func pruneBackups(dir string, retention int) error {
+	if retention == 0 {
+		return nil
+	}
+
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return e
+	}
+
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExtension) {
+			backups = append(backups, name)
+		}
+	}
+
+	if len(backups) <= retention {
+		return nil
+	}
+
+	// timestamps sort lexicographically, so the oldest backups come first
+	sort.Strings(backups)
+
+	var errs []error
+	for _, name := range backups[:len(backups)-retention] {
+		e := os.Remove(filepath.Join(dir, name))
+		if e != nil {
+			errs = append(errs, e)
+			continue
+		}
+		log.Debug().Str("service", "backup").Str("file", name).Msg("removed expired backup")
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Resolver) backupScheduler(o BackupOptions) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	log.Info().Str("service", "backup").Str("dir", o.Dir).Str("interval", o.Interval.String()).Int("retention", o.Retention).Bool("encrypted", len(r.backupKey) > 0).Msg("scheduled backups enabled")
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			path, e := r.BackupToDir(o.Dir)
+			if e != nil {
+				log.Error().Str("service", "backup").Err(e).Msg("scheduled backup failed")
+				continue
+			}
+			log.Info().Str("service", "backup").Str("file", path).Msg("scheduled backup completed")
+
+			e = pruneBackups(o.Dir, o.Retention)
+			if e != nil {
+				log.Error().Str("service", "backup").Err(e).Msg("unable to prune old backups")
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func TestBackupRestore(t *testing.T) {
+	source := newTestResolver(t, testOptions())
+	setLinks(t, source, map[string]string{
+		"abc": "https://example.com/a",
+		"def": "https://example.com/d",
+	})
+
+	var full bytes.Buffer
+	version, e := source.Backup(&full, 0)
+	if e != nil {
+		t.Fatalf("backup: %s", e)
+	}
+
+	setLinks(t, source, map[string]string{"ghi": "https://example.com/g"})
+	var incremental bytes.Buffer
+	_, e = source.Backup(&incremental, version)
+	if e != nil {
+		t.Fatalf("incremental backup: %s", e)
+	}
+
+	target := newTestResolver(t, testOptions())
+	e = target.Restore(&full)
+	if e != nil {
+		t.Fatalf("restore: %s", e)
+	}
+	for key, want := range map[string]string{"abc": "https://example.com/a", "def": "https://example.com/d", "ghi": ""} {
+		if got := getLink(t, target, key); got != want {
+			t.Errorf("after the full restore %s = %q, want %q", key, got, want)
+		}
+	}
+
+	e = target.Restore(&incremental)
+	if e != nil {
+		t.Fatalf("incremental restore: %s", e)
+	}
+	if got := getLink(t, target, "ghi"); got != "https://example.com/g" {
+		t.Errorf("after the incremental restore ghi = %q, want https://example.com/g", got)
+	}
+}
+
+func TestRestoreMigratesOwnership(t *testing.T) {
+	source := newTestResolver(t, testOptions())
+	// setLinks writes no ownership, as before workspaces existed
+	setLinks(t, source, map[string]string{"abc": "https://example.com"})
+
+	var backup bytes.Buffer
+	_, e := source.Backup(&backup, 0)
+	if e != nil {
+		t.Fatalf("backup: %s", e)
+	}
+
+	target := newTestResolver(t, testOptions())
+	e = target.Restore(&backup)
+	if e != nil {
+		t.Fatalf("restore: %s", e)
+	}
+
+	e = target.BadgerDB.View(func(txn *badger.Txn) error {
+		if n := target.CountLinks(txn, DefaultWorkspace); n != 1 {
+			t.Errorf("default workspace holds %d links, want 1", n)
+		}
+		return nil
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestBackupToDir(t *testing.T) {
+	r := newTestResolver(t, testOptions())
+	setLinks(t, r, map[string]string{"abc": "https://example.com"})
+
+	dir := filepath.Join(t.TempDir(), "backups")
+	path, e := r.BackupToDir(dir)
+	if e != nil {
+		t.Fatalf("backup to dir: %s", e)
+	}
+	if filepath.Dir(path) != dir || filepath.Ext(path) != backupExtension {
+		t.Errorf("backup written to %s, want a %s file in %s", path, backupExtension, dir)
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+
+	f, e := os.Open(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	target := newTestResolver(t, testOptions())
+	e = target.Restore(f)
+	if e != nil {
+		t.Fatalf("restore: %s", e)
+	}
+	if got := getLink(t, target, "abc"); got != "https://example.com" {
+		t.Errorf("abc = %q, want https://example.com", got)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	backups := []string{
+		"curt-20240101T000000Z.bak",
+		"curt-20240102T000000Z.bak",
+		"curt-20240103T000000Z.bak",
+		"curt-20240104T000000Z.bak",
+	}
+	others := []string{"notes.txt", "curt-upload.tmp"}
+
+	tests := []struct {
+		name      string
+		retention int
+		want      []string
+	}{
+		{"keep all", 0, backups},
+		{"keep fewer", 2, backups[2:]},
+		{"keep more than there are", 10, backups},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range append(append([]string{}, backups...), others...) {
+				e := os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+				if e != nil {
+					t.Fatal(e)
+				}
+			}
+
+			e := pruneBackups(dir, tt.retention)
+			if e != nil {
+				t.Fatalf("prune: %s", e)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			want := append(append([]string{}, tt.want...), others...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !equalStrings(got, want) {
+				t.Errorf("files left %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	BlocklistReject = "reject"
+	BlocklistWarn   = "warn"
+)
+
+// MetaFlagged is set in the user meta of the Curt(s) whose target matched a
+// blocklist with the warn action
+const MetaFlagged byte = 1
+
+var (
+	ErrURLBlocked  = errors.New("blocked")
+	ErrNoBlocklist = errors.New("no blocklist configured")
+)
+
+type BlocklistOptions struct {
+	// HostFiles list hostnames, one per line, each blocking itself and its
+	// subdomains, hosts file lines such as 0.0.0.0 evil.com are accepted
+	HostFiles []string
+	// HashPrefixFiles list hex SHA-256 prefixes, 4 to 32 bytes long, of URL
+	// expressions, as in the Safe Browsing lists
+	HashPrefixFiles []string
+	// RegexFiles list regular expressions matched against the whole URL
+	RegexFiles []string
+	// Action is reject, refusing matching targets, or warn, storing them
+	// flagged and showing a warning before redirecting
+	Action string
+	// CheckRedirects screens the targets again when redirecting, so that
+	// Curt(s) created before a blocklist update are caught too
+	CheckRedirects bool
+	// ReloadInterval between checks for changes of the files
+	ReloadInterval time.Duration
+}
+
+// BlocklistStats count the loaded rules
+type BlocklistStats struct {
+	Hosts        int
+	HashPrefixes int
+	Regexps      int
+	LoadedAt     time.Time
+}
+
+type blocklist struct {
+	options BlocklistOptions
+
+	mutex         sync.RWMutex
+	hosts         map[string]bool
+	prefixes      map[string]bool
+	prefixLengths []int
+	regexps       []*regexp.Regexp
+	modTime       time.Time
+	stats         BlocklistStats
+}
+
+func (o BlocklistOptions) validate() error {
+	if o.Action != BlocklistReject && o.Action != BlocklistWarn {
+		return fmt.Errorf("invalid blocklist action: %s, must be reject or warn", o.Action)
+	}
+	if o.ReloadInterval < 0 {
+		return fmt.Errorf("invalid blocklist reload interval: %s, must be 0 (disabled) or greater", o.ReloadInterval)
+	}
+	return nil
+}
+
+func (o BlocklistOptions) files() []string {
+	return append(append(append([]string{}, o.HostFiles...), o.HashPrefixFiles...), o.RegexFiles...)
+}
+
+func (o BlocklistOptions) enabled() bool {
+	return len(o.files()) > 0
+}
+
+// readLines returns the lines of path, without blanks and # comments
+func readLines(path string, fn func(line string) error) error {
+	f, e := os.Open(path)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e := fn(line)
+		if e != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, e)
+		}
+	}
+	return scanner.Err()
+}
+
+// latestModTime returns the latest modification time of files
+func latestModTime(files []string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, e := os.Stat(file)
+		if e != nil {
+			return latest, e
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads every file, the current rules are only replaced if all of
+// them are valid
+func (b *blocklist) load() error {
+	modTime, e := latestModTime(b.options.files())
+	if e != nil {
+		return e
+	}
+
+	hosts := map[string]bool{}
+	for _, file := range b.options.HostFiles {
+		e := readLines(file, func(line string) error {
+			fields := strings.Fields(line)
+			// hosts file format, the address comes first
+			host := fields[len(fields)-1]
+			ascii, e := idnaProfile.ToASCII(strings.TrimSuffix(host, "."))
+			if e != nil || ascii == "" {
+				return fmt.Errorf("invalid hostname: %s", host)
+			}
+			hosts[ascii] = true
+			return nil
+		})
+		if e != nil {
+			return e
+		}
+	}
+
+	prefixes := map[string]bool{}
+	lengths := map[int]bool{}
+	for _, file := range b.options.HashPrefixFiles {
+		e := readLines(file, func(line string) error {
+			prefix, e := hex.DecodeString(line)
+			if e != nil || len(prefix) < 4 || len(prefix) > sha256.Size {
+				return fmt.Errorf("invalid hash prefix: %s, must be 4 to 32 hex encoded bytes", line)
+			}
+			prefixes[string(prefix)] = true
+			lengths[len(prefix)] = true
+			return nil
+		})
+		if e != nil {
+			return e
+		}
+	}
+	prefixLengths := []int{}
+	for length := range lengths {
+		prefixLengths = append(prefixLengths, length)
+	}
+	sort.Ints(prefixLengths)
+
+	regexps := []*regexp.Regexp{}
+	for _, file := range b.options.RegexFiles {
+		e := readLines(file, func(line string) error {
+			re, e := regexp.Compile(line)
+			if e != nil {
+				return e
+			}
+			regexps = append(regexps, re)
+			return nil
+		})
+		if e != nil {
+			return e
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.hosts = hosts
+	b.prefixes = prefixes
+	b.prefixLengths = prefixLengths
+	b.regexps = regexps
+	b.modTime = modTime
+	b.stats = BlocklistStats{
+		Hosts:        len(hosts),
+		HashPrefixes: len(prefixes),
+		Regexps:      len(regexps),
+		LoadedAt:     time.Now(),
+	}
+
+	log.Info().Str("service", "blocklist").Int("hosts", len(hosts)).Int("hash_prefixes", len(prefixes)).Int("regexps", len(regexps)).Msg("blocklists loaded")
+	return nil
+}
+
+// urlExpressions returns the host suffix and path prefix combinations of u
+// that Safe Browsing hashes, e.g. a.b.com/1/2.html?x, a.b.com/1/2.html,
+// a.b.com/1/, a.b.com/, b.com/1/2.html?x and so on
+func urlExpressions(u *url.URL) []string {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		// at most 4 suffixes made of the last 5 labels, the TLD alone is skipped
+		start := len(labels) - 5
+		if start < 1 {
+			start = 1
+		}
+		for i := start; i < len(labels)-1; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{}
+	add := func(p string) {
+		if !contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	if u.RawQuery != "" {
+		add(path + "?" + u.RawQuery)
+	}
+	add(path)
+	// the root and at most 3 more prefixes, the last segment is not a directory
+	prefix := "/"
+	add(prefix)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments)-1 && i < 3; i++ {
+		prefix += segments[i] + "/"
+		add(prefix)
+	}
+
+	expressions := []string{}
+	for _, h := range hosts {
+		for _, p := range paths {
+			expressions = append(expressions, h+p)
+		}
+	}
+	return expressions
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the rule target matches, if any
+func (b *blocklist) match(target string) (string, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, re := range b.regexps {
+		if re.MatchString(target) {
+			return "regexp " + re.String(), true
+		}
+	}
+
+	u, e := url.Parse(target)
+	if e != nil || u.Host == "" {
+		return "", false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for h := host; h != ""; {
+		if b.hosts[h] {
+			return "host " + h, true
+		}
+		_, parent, found := strings.Cut(h, ".")
+		if !found {
+			break
+		}
+		h = parent
+	}
+
+	if len(b.prefixes) > 0 {
+		for _, expression := range urlExpressions(u) {
+			hash := sha256.Sum256([]byte(expression))
+			for _, length := range b.prefixLengths {
+				if b.prefixes[string(hash[:length])] {
+					return "hash prefix " + hex.EncodeToString(hash[:length]), true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// ScreenTarget checks target against the blocklists, returning the user meta
+// to store it with, or ErrURLBlocked
+func (r *Resolver) ScreenTarget(target string) (byte, error) {
+	if !r.blocklist.options.enabled() {
+		return 0, nil
+	}
+
+	rule, ok := r.blocklist.match(target)
+	if !ok {
+		return 0, nil
+	}
+
+	log.Warn().Str("service", "blocklist").Str("url", target).Str("rule", rule).Str("action", r.blocklist.options.Action).Msg("target URL matched a blocklist")
+	if r.blocklist.options.Action == BlocklistReject {
+		return 0, ErrURLBlocked
+	}
+	return MetaFlagged, nil
+}
+
+// ScreenRedirect tells whether the redirect to target, stored with meta,
+// must be blocked or preceded by a warning
+func (r *Resolver) ScreenRedirect(target string, meta byte) (blocked bool, warn bool) {
+	if meta&MetaFlagged != 0 {
+		warn = true
+	}
+	if !r.blocklist.options.CheckRedirects || !r.blocklist.options.enabled() {
+		return false, warn
+	}
+
+	_, ok := r.blocklist.match(target)
+	if !ok {
+		return false, warn
+	}
+	if r.blocklist.options.Action == BlocklistReject {
+		return true, false
+	}
+	return false, true
+}
+
+// ReloadBlocklists reads the blocklist files again, keeping the current
+// rules if any of them is invalid
+func (r *Resolver) ReloadBlocklists() (BlocklistStats, error) {
+	if !r.blocklist.options.enabled() {
+		return BlocklistStats{}, ErrNoBlocklist
+	}
+	e := r.blocklist.load()
+	r.blocklist.mutex.RLock()
+	defer r.blocklist.mutex.RUnlock()
+	return r.blocklist.stats, e
+}
+
+func (r *Resolver) blocklistScheduler() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.blocklist.options.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			modTime, e := latestModTime(r.blocklist.options.files())
+			r.blocklist.mutex.RLock()
+			changed := e == nil && !modTime.Equal(r.blocklist.modTime)
+			r.blocklist.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+
+			e = r.blocklist.load()
+			if e != nil {
+				log.Error().Str("service", "blocklist").Err(e).Msg("unable to reload the blocklists, keeping the current ones")
+			}
+		}
+	}
+}
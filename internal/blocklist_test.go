@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBlocklist writes lines to a file in dir and returns its path
+func writeBlocklist(t *testing.T, dir string, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	e := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return path
+}
+
+func hashPrefix(expression string, length int) string {
+	hash := sha256.Sum256([]byte(expression))
+	return hex.EncodeToString(hash[:length])
+}
+
+func TestURLExpressions(t *testing.T) {
+	u, _ := url.Parse("http://a.b.c/1/2.html?param=1")
+	want := []string{
+		"a.b.c/1/2.html?param=1",
+		"a.b.c/1/2.html",
+		"a.b.c/",
+		"a.b.c/1/",
+		"b.c/1/2.html?param=1",
+		"b.c/1/2.html",
+		"b.c/",
+		"b.c/1/",
+	}
+	if got := urlExpressions(u); !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	u, _ = url.Parse("http://1.2.3.4/")
+	if got := urlExpressions(u); !equalStrings(got, []string{"1.2.3.4/"}) {
+		t.Errorf("IP address: got %v, want [1.2.3.4/]", got)
+	}
+
+	u, _ = url.Parse("http://a.b.c.d.e.f.g/")
+	hosts := map[string]bool{}
+	for _, expression := range urlExpressions(u) {
+		hosts[strings.TrimSuffix(expression, "/")] = true
+	}
+	for _, host := range []string{"a.b.c.d.e.f.g", "c.d.e.f.g", "d.e.f.g", "e.f.g", "f.g"} {
+		if !hosts[host] {
+			t.Errorf("missing host suffix %s in %v", host, hosts)
+		}
+	}
+	if len(hosts) != 5 {
+		t.Errorf("got %d host suffixes, want 5: %v", len(hosts), hosts)
+	}
+}
+
+func TestBlocklistMatch(t *testing.T) {
+	dir := t.TempDir()
+	o := BlocklistOptions{
+		HostFiles: []string{writeBlocklist(t, dir, "hosts",
+			"# comment",
+			"",
+			"evil.com",
+			"0.0.0.0 tracker.net",
+			"bücher.example",
+		)},
+		HashPrefixFiles: []string{writeBlocklist(t, dir, "prefixes",
+			hashPrefix("phish.org/login/", 4),
+			hashPrefix("bad.io/", 32),
+		)},
+		RegexFiles: []string{writeBlocklist(t, dir, "regexps",
+			`^https?://[^/]+/wp-admin/`,
+		)},
+		Action: BlocklistReject,
+	}
+	b := blocklist{options: o}
+	e := b.load()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		target string
+		rule   string
+	}{
+		{"https://evil.com/", "host evil.com"},
+		{"https://www.EVIL.com./page", "host evil.com"},
+		{"https://tracker.net/pixel", "host tracker.net"},
+		{"https://xn--bcher-kva.example/", "host xn--bcher-kva.example"},
+		{"https://notevil.com/", ""},
+		{"https://evil.com.example.org/", ""},
+		{"https://www.phish.org/login/form.html?next=1", "hash prefix " + hashPrefix("phish.org/login/", 4)},
+		{"https://phish.org/other/", ""},
+		{"http://sub.bad.io/anything", "hash prefix " + hashPrefix("bad.io/", 32)},
+		{"https://blog.example.com/wp-admin/setup.php", "regexp ^https?://[^/]+/wp-admin/"},
+		{"https://example.com/", ""},
+	}
+	for _, tt := range tests {
+		rule, ok := b.match(tt.target)
+		if ok != (tt.rule != "") || rule != tt.rule {
+			t.Errorf("match(%s) = %q, %t, want %q", tt.target, rule, ok, tt.rule)
+		}
+	}
+}
+
+func TestBlocklistLoadKeepsRulesOnError(t *testing.T) {
+	dir := t.TempDir()
+	hosts := writeBlocklist(t, dir, "hosts", "evil.com")
+	b := blocklist{options: BlocklistOptions{HostFiles: []string{hosts}, Action: BlocklistReject}}
+	e := b.load()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	b.options.HashPrefixFiles = []string{writeBlocklist(t, dir, "prefixes", "abc")}
+	e = b.load()
+	if e == nil || !strings.Contains(e.Error(), "prefixes:1") {
+		t.Errorf("got %v, want the invalid prefix reported with its file and line", e)
+	}
+	if _, ok := b.match("https://evil.com/"); !ok {
+		t.Error("the current rules were dropped by a failed load")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	hosts := writeBlocklist(t, t.TempDir(), "hosts", "evil.com")
+
+	tests := []struct {
+		action         string
+		checkRedirects bool
+		target         string
+		meta           byte
+		err            error
+		blocked, warn  bool
+	}{
+		{BlocklistReject, false, "https://evil.com/", 0, ErrURLBlocked, false, false},
+		{BlocklistReject, true, "https://evil.com/", 0, ErrURLBlocked, true, false},
+		{BlocklistWarn, false, "https://evil.com/", MetaFlagged, nil, false, true},
+		{BlocklistWarn, true, "https://evil.com/", MetaFlagged, nil, false, true},
+		{BlocklistReject, true, "https://example.com/", 0, nil, false, false},
+	}
+	for _, tt := range tests {
+		o := testOptions()
+		o.Blocklist = BlocklistOptions{HostFiles: []string{hosts}, Action: tt.action, CheckRedirects: tt.checkRedirects, ReloadInterval: time.Minute}
+		r := newTestResolver(t, o)
+
+		meta, e := r.ScreenTarget(tt.target)
+		if e != tt.err || (e == nil && meta != tt.meta) {
+			t.Errorf("%s %s: ScreenTarget = %d, %v, want %d, %v", tt.action, tt.target, meta, e, tt.meta, tt.err)
+		}
+		// as if stored before the blocklist update
+		blocked, warn := r.ScreenRedirect(tt.target, 0)
+		wantBlocked, wantWarn := tt.blocked, tt.warn && tt.checkRedirects
+		if blocked != wantBlocked || warn != wantWarn {
+			t.Errorf("%s %s check redirects %t: ScreenRedirect = %t, %t, want %t, %t", tt.action, tt.target, tt.checkRedirects, blocked, warn, wantBlocked, wantWarn)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+var (
+	ErrRedirectLoop = errors.New("the URL leads back to the Curt itself")
+	ErrChainTooDeep = errors.New("the URL goes through too many Curt(s)")
+	ErrUnknownCurt  = errors.New("the URL is a Curt that does not exist")
+)
+
+// curtOf returns the domain and the key of target when it is a redirect of
+// a configured domain, the query and the fragment are ignored, like the
+// redirect routes do
+func (r *Resolver) curtOf(target string) (Domain, string, bool) {
+	u, e := url.Parse(target)
+	if e != nil || u.Host == "" {
+		return Domain{}, "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, d := range r.domains {
+		if d.Name != host {
+			continue
+		}
+		for _, path := range d.redirectPaths {
+			key, ok := strings.CutPrefix(u.Path, path)
+			if ok && r.ValidateKey(key) == nil {
+				return d, key, true
+			}
+		}
+	}
+	return Domain{}, "", false
+}
+
+// ResolveTarget follows target while it is a Curt, returning the URL it
+// finally leads to. self is the storage key of the Curt target is stored
+// in, reaching it is a loop, nil if it does not exist yet.
+func (r *Resolver) ResolveTarget(txn *badger.Txn, target string, self []byte) (string, error) {
+	visited := map[string]bool{}
+	if self != nil {
+		visited[string(self)] = true
+	}
+
+	for depth := 0; ; depth++ {
+		d, key, ok := r.curtOf(target)
+		if !ok {
+			return target, nil
+		}
+
+		storageKey := d.Key(key)
+		if visited[string(storageKey)] {
+			return "", ErrRedirectLoop
+		}
+		if depth >= r.links.MaxChainDepth {
+			return "", ErrChainTooDeep
+		}
+		visited[string(storageKey)] = true
+
+		item, e := txn.Get(storageKey)
+		if e == badger.ErrKeyNotFound {
+			return "", ErrUnknownCurt
+		}
+		if e != nil {
+			return "", e
+		}
+		v, e := item.ValueCopy(nil)
+		if e != nil {
+			return "", e
+		}
+		target = string(v)
+	}
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func TestResolveTarget(t *testing.T) {
+	o := testOptions()
+	o.Domains = []string{"https://brand.ly"}
+	o.Links.MaxChainDepth = 2
+	r := newTestResolver(t, o)
+	brand, _ := r.Domain("brand.ly")
+
+	setLinks(t, r, map[string]string{
+		"a":  "https://example.com",
+		"b":  "http://localhost:8080/c/a",
+		"d":  "http://localhost:8080/c/b",
+		"l1": "http://localhost:8080/c/l2",
+		"l2": "http://localhost:8080/c/l1",
+	})
+	e := r.BadgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(brand.Key("x"), []byte("http://localhost:8080/c/a"))
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		self   string
+		want   string
+		err    error
+	}{
+		{"not a Curt", "https://example.org/c/a", "", "https://example.org/c/a", nil},
+		{"reserved key", "http://localhost:8080/c/status", "", "http://localhost:8080/c/status", nil},
+		{"one hop", "http://localhost:8080/c/a", "", "https://example.com", nil},
+		{"query and fragment ignored", "http://localhost:8080/c/a?utm=x#top", "", "https://example.com", nil},
+		{"host case ignored", "http://LOCALHOST:8080/c/a", "", "https://example.com", nil},
+		{"as many hops as the max depth", "http://localhost:8080/c/b", "", "https://example.com", nil},
+		{"other domain", "https://brand.ly/c/x", "", "https://example.com", nil},
+		{"deeper than the max depth", "http://localhost:8080/c/d", "", "", ErrChainTooDeep},
+		{"unknown Curt", "http://localhost:8080/c/missing", "", "", ErrUnknownCurt},
+		{"itself", "http://localhost:8080/c/new", "new", "", ErrRedirectLoop},
+		{"back to itself", "http://localhost:8080/c/b", "a", "", ErrRedirectLoop},
+		{"loop between others", "http://localhost:8080/c/l1", "", "", ErrRedirectLoop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var self []byte
+			if tt.self != "" {
+				self = []byte(tt.self)
+			}
+			var got string
+			e := r.BadgerDB.View(func(txn *badger.Txn) error {
+				var e error
+				got, e = r.ResolveTarget(txn, tt.target, self)
+				return e
+			})
+			if e != tt.err {
+				t.Fatalf("got error %v, want %v", e, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTargetWithoutChains(t *testing.T) {
+	o := testOptions()
+	o.Links.MaxChainDepth = 0
+	r := newTestResolver(t, o)
+	setLinks(t, r, map[string]string{"a": "https://example.com"})
+
+	e := r.BadgerDB.View(func(txn *badger.Txn) error {
+		_, e := r.ResolveTarget(txn, "http://localhost:8080/c/a", nil)
+		return e
+	})
+	if e != ErrChainTooDeep {
+		t.Errorf("got %v, want %v", e, ErrChainTooDeep)
+	}
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	lsmSizeDesc = prometheus.NewDesc(
+		"curt_badger_lsm_size_bytes",
+		"Size of the LSM tree, refreshed by badger once a minute.",
+		nil, nil)
+	vlogSizeDesc = prometheus.NewDesc(
+		"curt_badger_vlog_size_bytes",
+		"Size of the value log, refreshed by badger once a minute.",
+		nil, nil)
+	gcRunsDesc = prometheus.NewDesc(
+		"curt_badger_gc_runs_total",
+		"Value log GC runs, scheduled or on demand.",
+		nil, nil)
+	gcFailuresDesc = prometheus.NewDesc(
+		"curt_badger_gc_failures_total",
+		"Value log GC runs that failed.",
+		nil, nil)
+	gcRewritesDesc = prometheus.NewDesc(
+		"curt_badger_gc_rewrites_total",
+		"Value log files rewritten by the GC.",
+		nil, nil)
+	gcReclaimedDesc = prometheus.NewDesc(
+		"curt_badger_gc_reclaimed_bytes_total",
+		"Disk space reclaimed by the value log GC.",
+		nil, nil)
+)
+
+// databaseCollector reads the database sizes and GC totals on every scrape
+type databaseCollector struct {
+	r *Resolver
+}
+
+// Collector exposes the database metrics to Prometheus
+func (r *Resolver) Collector() prometheus.Collector {
+	return databaseCollector{r: r}
+}
+
+func (c databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lsmSizeDesc
+	ch <- vlogSizeDesc
+	ch <- gcRunsDesc
+	ch <- gcFailuresDesc
+	ch <- gcRewritesDesc
+	ch <- gcReclaimedDesc
+}
+
+func (c databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	lsm, vlog := c.r.BadgerDB.Size()
+	stats := c.r.GCStats()
+
+	ch <- prometheus.MustNewConstMetric(lsmSizeDesc, prometheus.GaugeValue, float64(lsm))
+	ch <- prometheus.MustNewConstMetric(vlogSizeDesc, prometheus.GaugeValue, float64(vlog))
+	ch <- prometheus.MustNewConstMetric(gcRunsDesc, prometheus.CounterValue, float64(stats.Runs))
+	ch <- prometheus.MustNewConstMetric(gcFailuresDesc, prometheus.CounterValue, float64(stats.Failures))
+	ch <- prometheus.MustNewConstMetric(gcRewritesDesc, prometheus.CounterValue, float64(stats.Rewrites))
+	ch <- prometheus.MustNewConstMetric(gcReclaimedDesc, prometheus.CounterValue, float64(stats.ReclaimedBytes))
+}
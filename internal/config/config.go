@@ -0,0 +1,254 @@
+package config
+
+import (
+	"time"
+)
+
+// Config holds every setting. Each leaf is read, in increasing order of
+// precedence, from the defaults, the config file (by its yaml/toml key), the
+// environment and the command line (both by the env tag, the first name is
+// the canonical one, the others are aliases). Settings tagged secret are
+// redacted by Print.
+type Config struct {
+	Port          string          `yaml:"port" toml:"port" env:"PORT" usage:"server port"`
+	AdminAddr     string          `yaml:"admin_addr" toml:"admin_addr" env:"ADMIN_ADDR" usage:"bind address of the admin listener, e.g. 10.0.0.1:8080, overrides PORT"`
+	PublicAddr    string          `yaml:"public_addr" toml:"public_addr" env:"PUBLIC_ADDR" usage:"bind address of a public listener serving only the redirects, which are then removed from the admin listener"`
+	Host          string          `yaml:"host" toml:"host" env:"HOST" usage:"base url used to build the Curt(s)"`
+	Domains       []string        `yaml:"domains" toml:"domains" env:"DOMAINS" usage:"comma separated base URLs of additional short domains, e.g. https://brand.ly, each with its own keys"`
+	BasePath      string          `yaml:"base_path" toml:"base_path" env:"BASE_PATH" usage:"prefix of every route, e.g. /short behind a proxy forwarding that path to Curt"`
+	RootRedirects bool            `yaml:"root_redirects" toml:"root_redirects" env:"ROOT_REDIRECTS" usage:"serve the redirects at /<key> too, and build the Curt(s) with it"`
+	ReservedKeys  []string        `yaml:"reserved_keys" toml:"reserved_keys" env:"RESERVED_KEYS" usage:"comma separated keys that can't be claimed, on top of the paths used by Curt"`
+	MaxChainDepth int             `yaml:"max_chain_depth" toml:"max_chain_depth" env:"MAX_CHAIN_DEPTH" usage:"how many Curt(s) a target URL may go through, 0 rejects targets that are Curt(s)"`
+	XAPIKey       string          `yaml:"x_api_key" toml:"x_api_key" env:"X_API_KEY,API_KEY" secret:"true" usage:"API key required in the X-API-Key header, as is, as sha256:<hex> or as an argon2 hash, empty disables the auth"`
+	XAPIKeyFile   string          `yaml:"x_api_key_file" toml:"x_api_key_file" env:"X_API_KEY_FILE,API_KEY_FILE" usage:"file holding X_API_KEY, e.g. a secret mount"`
+	APIKeys       []string        `yaml:"api_keys" toml:"api_keys" env:"API_KEYS" secret:"true" usage:"comma separated name:key pairs, each key authenticating as its name, on top of X_API_KEY named default, name@workspace:key puts the key in a workspace"`
+	APIKeysFile   string          `yaml:"api_keys_file" toml:"api_keys_file" env:"API_KEYS_FILE" usage:"file of name:key pairs, one per line, on top of API_KEYS"`
+	APIKeyRoles   []string        `yaml:"api_key_roles" toml:"api_key_roles" env:"API_KEY_ROLES" usage:"comma separated name:role pairs, the role of each key: viewer, editor or admin"`
+	APIKeyRole    string          `yaml:"api_key_role" toml:"api_key_role" env:"API_KEY_DEFAULT_ROLE" usage:"role of the keys missing from API_KEY_ROLES, but for X_API_KEY, an admin"`
+	JWT           JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Workspace     WorkspaceConfig `yaml:"workspace" toml:"workspace"`
+	URL           URLConfig       `yaml:"url" toml:"url"`
+	Blocklist     BlocklistConfig `yaml:"blocklist" toml:"blocklist"`
+	Log           LogConfig       `yaml:"log" toml:"log"`
+	Database      DatabaseConfig  `yaml:"database" toml:"database"`
+	GC            GCConfig        `yaml:"gc" toml:"gc"`
+	Backup        BackupConfig    `yaml:"backup" toml:"backup"`
+	TLS           TLSConfig       `yaml:"tls" toml:"tls"`
+	CORS          CORSConfig      `yaml:"cors" toml:"cors"`
+	RateLimit     RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Proxy         ProxyConfig     `yaml:"proxy" toml:"proxy"`
+	Metrics       MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Tracing       TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Health        HealthConfig    `yaml:"health" toml:"health"`
+	Shutdown      ShutdownConfig  `yaml:"shutdown" toml:"shutdown"`
+}
+
+type JWTConfig struct {
+	Issuer          string   `yaml:"issuer" toml:"issuer" env:"JWT_ISSUER" usage:"issuer the bearer tokens must come from, without a JWKS URL or file its OpenID configuration gives the JWKS URL"`
+	Audience        string   `yaml:"audience" toml:"audience" env:"JWT_AUDIENCE" usage:"audience the bearer tokens must be issued for"`
+	JWKSURL         string   `yaml:"jwks_url" toml:"jwks_url" env:"JWT_JWKS_URL" usage:"URL of the JWKS verifying the bearer tokens"`
+	JWKSFile        string   `yaml:"jwks_file" toml:"jwks_file" env:"JWT_JWKS_FILE" usage:"file holding the JWKS, or PEM public keys, verifying the bearer tokens"`
+	RefreshInterval Duration `yaml:"refresh_interval" toml:"refresh_interval" env:"JWT_JWKS_REFRESH_INTERVAL" usage:"interval between loads of the JWKS, 0 disables them"`
+	Algorithms      []string `yaml:"algorithms" toml:"algorithms" env:"JWT_ALGORITHMS" usage:"comma separated signing algorithms the bearer tokens may use"`
+	Leeway          Duration `yaml:"leeway" toml:"leeway" env:"JWT_LEEWAY" usage:"clock skew allowed checking the expiration of the bearer tokens"`
+	SubjectClaim    string   `yaml:"subject_claim" toml:"subject_claim" env:"JWT_SUBJECT_CLAIM" usage:"claim naming the actor and owner of the links, e.g. sub or email"`
+	RolesClaim      string   `yaml:"roles_claim" toml:"roles_claim" env:"JWT_ROLES_CLAIM" usage:"claim holding the roles, nested ones are reached with dots, e.g. realm_access.roles"`
+	ScopesClaim     string   `yaml:"scopes_claim" toml:"scopes_claim" env:"JWT_SCOPES_CLAIM" usage:"claim holding the scopes, as a list or space separated"`
+	WorkspaceClaim  string   `yaml:"workspace_claim" toml:"workspace_claim" env:"JWT_WORKSPACE_CLAIM" usage:"claim holding the workspace, the default one when missing"`
+	RequiredScopes  []string `yaml:"required_scopes" toml:"required_scopes" env:"JWT_REQUIRED_SCOPES" usage:"comma separated scopes every bearer token must grant"`
+	RoleMap         []string `yaml:"role_map" toml:"role_map" env:"JWT_ROLE_MAP" usage:"comma separated value:role pairs mapping the values of the roles claim to viewer, editor or admin"`
+	RoleNames       bool     `yaml:"role_names" toml:"role_names" env:"JWT_ROLE_NAMES" usage:"let the values of the roles claim named viewer, editor or admin grant that role without JWT_ROLE_MAP, only for issuers whose users can't pick such names"`
+	DefaultRole     string   `yaml:"default_role" toml:"default_role" env:"JWT_DEFAULT_ROLE" usage:"role of the bearer tokens without one, empty for none"`
+}
+
+type WorkspaceConfig struct {
+	DefaultQuota int      `yaml:"default_quota" toml:"default_quota" env:"WORKSPACE_DEFAULT_QUOTA" usage:"maximum number of links of the workspaces without a quota of their own, 0 for no cap"`
+	Quotas       []string `yaml:"quotas" toml:"quotas" env:"WORKSPACE_QUOTAS" usage:"comma separated workspace:links pairs, the maximum number of links of each workspace"`
+}
+
+type URLConfig struct {
+	Schemes      []string `yaml:"schemes" toml:"schemes" env:"URL_SCHEMES" usage:"comma separated schemes target URLs may use"`
+	MaxLength    int      `yaml:"max_length" toml:"max_length" env:"URL_MAX_LENGTH" usage:"maximum length of a target URL"`
+	AllowDomains []string `yaml:"allow_domains" toml:"allow_domains" env:"URL_ALLOW_DOMAINS" usage:"comma separated domains target URLs must point to, e.g. example.com,*.example.com, empty allows any"`
+	DenyDomains  []string `yaml:"deny_domains" toml:"deny_domains" env:"URL_DENY_DOMAINS" usage:"comma separated domains target URLs must not point to, e.g. *.evil.com"`
+}
+
+type BlocklistConfig struct {
+	HostFiles       []string `yaml:"host_files" toml:"host_files" env:"BLOCKLIST_HOST_FILES" usage:"comma separated files of blocked hostnames, one per line, blocking their subdomains too"`
+	HashPrefixFiles []string `yaml:"hash_prefix_files" toml:"hash_prefix_files" env:"BLOCKLIST_HASH_PREFIX_FILES" usage:"comma separated files of hex SHA-256 URL hash prefixes, one per line, as in the Safe Browsing lists"`
+	RegexFiles      []string `yaml:"regex_files" toml:"regex_files" env:"BLOCKLIST_REGEX_FILES" usage:"comma separated files of regular expressions, one per line, matched against the whole URL"`
+	Action          string   `yaml:"action" toml:"action" env:"BLOCKLIST_ACTION" usage:"what to do with matching URLs: reject, or warn to store them flagged and show a warning page"`
+	CheckRedirects  bool     `yaml:"check_redirects" toml:"check_redirects" env:"BLOCKLIST_CHECK_REDIRECTS" usage:"check the URLs again when redirecting"`
+	ReloadInterval  Duration `yaml:"reload_interval" toml:"reload_interval" env:"BLOCKLIST_RELOAD_INTERVAL" usage:"interval between checks for changes of the blocklist files, 0 disables them"`
+}
+
+type LogConfig struct {
+	Level          string `yaml:"level" toml:"level" env:"LOG_LEVEL" usage:"log level, defaults to DEBUG"`
+	Format         string `yaml:"format" toml:"format" env:"LOG_FORMAT" usage:"log format: console or json"`
+	File           string `yaml:"file" toml:"file" env:"LOG_FILE" usage:"write the logs to this file instead of stdout, rotating it by size"`
+	FileMaxSize    int    `yaml:"file_max_size" toml:"file_max_size" env:"LOG_FILE_MAX_SIZE" usage:"size in megabytes at which the log file is rotated"`
+	FileMaxBackups int    `yaml:"file_max_backups" toml:"file_max_backups" env:"LOG_FILE_MAX_BACKUPS" usage:"number of rotated log files to keep, 0 keeps all"`
+	FileMaxAge     int    `yaml:"file_max_age" toml:"file_max_age" env:"LOG_FILE_MAX_AGE" usage:"days to keep rotated log files, 0 keeps them regardless of age"`
+	FileCompress   bool   `yaml:"file_compress" toml:"file_compress" env:"LOG_FILE_COMPRESS" usage:"gzip rotated log files"`
+}
+
+type DatabaseConfig struct {
+	Dir                   string   `yaml:"dir" toml:"dir" env:"DATA_DIR" usage:"database directory"`
+	InMemory              bool     `yaml:"in_memory" toml:"in_memory" env:"IN_MEMORY" usage:"keep the database in memory only, nothing is persisted"`
+	ValueLogFileSize      Size     `yaml:"value_log_file_size" toml:"value_log_file_size" env:"VALUE_LOG_FILE_SIZE" usage:"maximum size of a single value log file"`
+	SyncWrites            bool     `yaml:"sync_writes" toml:"sync_writes" env:"SYNC_WRITES" usage:"sync every write to disk before acknowledging it"`
+	Compression           string   `yaml:"compression" toml:"compression" env:"COMPRESSION" usage:"block compression: none, snappy or zstd"`
+	BlockCacheSize        Size     `yaml:"block_cache_size" toml:"block_cache_size" env:"BLOCK_CACHE_SIZE" usage:"block cache size"`
+	IndexCacheSize        Size     `yaml:"index_cache_size" toml:"index_cache_size" env:"INDEX_CACHE_SIZE" usage:"index cache size, 0 keeps indexes in memory"`
+	NumCompactors         int      `yaml:"num_compactors" toml:"num_compactors" env:"NUM_COMPACTORS" usage:"number of LSM compaction workers, 0 or at least 2"`
+	CompactL0OnClose      bool     `yaml:"compact_l0_on_close" toml:"compact_l0_on_close" env:"COMPACT_L0_ON_CLOSE" usage:"compact level 0 of the LSM tree on close"`
+	EncryptionKey         string   `yaml:"encryption_key" toml:"encryption_key" env:"ENCRYPTION_KEY" secret:"true" usage:"AES key, 16, 24 or 32 bytes long, used to encrypt the database"`
+	EncryptionKeyFile     string   `yaml:"encryption_key_file" toml:"encryption_key_file" env:"ENCRYPTION_KEY_FILE" usage:"file containing the encryption key"`
+	EncryptionKeyRotation Duration `yaml:"encryption_key_rotation" toml:"encryption_key_rotation" env:"ENCRYPTION_KEY_ROTATION" usage:"interval between data key rotations"`
+}
+
+type GCConfig struct {
+	Interval     Duration `yaml:"interval" toml:"interval" env:"GC_INTERVAL" usage:"interval between value log GCs, 0 disables them"`
+	DiscardRatio float64  `yaml:"discard_ratio" toml:"discard_ratio" env:"GC_DISCARD_RATIO" usage:"fraction of a value log file that must be discardable to rewrite it"`
+	Flatten      bool     `yaml:"flatten" toml:"flatten" env:"GC_FLATTEN" usage:"compact the whole LSM tree before every scheduled GC"`
+}
+
+type BackupConfig struct {
+	Dir       string   `yaml:"dir" toml:"dir" env:"BACKUP_DIR" usage:"directory for scheduled backups, empty disables them"`
+	Interval  Duration `yaml:"interval" toml:"interval" env:"BACKUP_INTERVAL" usage:"interval between scheduled backups"`
+	Retention int      `yaml:"retention" toml:"retention" env:"BACKUP_RETENTION" usage:"number of scheduled backups to keep, 0 keeps all"`
+}
+
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate, enables HTTPS, reloaded when it changes on disk"`
+	KeyFile      string `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+	MinVersion   string `yaml:"min_version" toml:"min_version" env:"TLS_MIN_VERSION" usage:"minimum TLS version: 1.2 or 1.3"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CA bundle, requires client certificates signed by it on the /c API and the admin routes"`
+	RedirectAddr string `yaml:"redirect_addr" toml:"redirect_addr" env:"TLS_REDIRECT_ADDR" usage:"address of a plain HTTP listener redirecting to HTTPS, e.g. :80"`
+}
+
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled" toml:"enabled" env:"CORS_ENABLED" usage:"send CORS headers, when disabled browsers reject every cross-origin request"`
+	AllowOrigins     []string `yaml:"allow_origins" toml:"allow_origins" env:"CORS_ALLOW_ORIGINS" usage:"comma separated allowed origins, e.g. https://app.example.com,https://*.example.com, or * for any"`
+	AllowMethods     []string `yaml:"allow_methods" toml:"allow_methods" env:"CORS_ALLOW_METHODS" usage:"comma separated allowed methods"`
+	AllowHeaders     []string `yaml:"allow_headers" toml:"allow_headers" env:"CORS_ALLOW_HEADERS" usage:"comma separated allowed request headers"`
+	ExposeHeaders    []string `yaml:"expose_headers" toml:"expose_headers" env:"CORS_EXPOSE_HEADERS" usage:"comma separated response headers readable by the browser"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" usage:"allow cookies and credentials, requires explicit origins"`
+	MaxAge           Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" usage:"how long browsers cache a preflight response"`
+}
+
+type MetricsConfig struct {
+	Addr  string `yaml:"addr" toml:"addr" env:"METRICS_ADDR" usage:"address of a dedicated /metrics listener, e.g. 127.0.0.1:9090"`
+	Token string `yaml:"token" toml:"token" env:"METRICS_TOKEN" secret:"true" usage:"bearer token required to read /metrics"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" usage:"span exporter: none, otlp or stdout"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT" usage:"host:port of the OTLP/HTTP collector, defaults to the OTEL_EXPORTER_OTLP_* variables"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_INSECURE" usage:"send spans to the OTLP collector over plain HTTP"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"fraction of new traces to sample, between 0 and 1"`
+}
+
+type RateLimitConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled" env:"RATE_LIMIT_ENABLED" usage:"limit the API requests per API key or token subject, the redirects and the failed authentications per client IP"`
+	Redirect Rate   `yaml:"redirect" toml:"redirect" env:"RATE_LIMIT_REDIRECT" usage:"rate of the redirects, as requests/period, 0 for unlimited"`
+	Create   Rate   `yaml:"create" toml:"create" env:"RATE_LIMIT_CREATE" usage:"rate of the Curt(s) created with POST /c, as requests/period, 0 for unlimited"`
+	Admin    Rate   `yaml:"admin" toml:"admin" env:"RATE_LIMIT_ADMIN" usage:"rate of the /admin requests and of the rest of the /c API, as requests/period, 0 for unlimited"`
+	Auth     Rate   `yaml:"auth" toml:"auth" env:"RATE_LIMIT_AUTH" usage:"rate of the failed authentications per client IP, as requests/period, 0 for unlimited"`
+	RedisURL string `yaml:"redis_url" toml:"redis_url" env:"RATE_LIMIT_REDIS_URL" usage:"Redis server sharing the limits between instances, e.g. redis://localhost:6379/0, empty keeps them in memory" secret:"true"`
+}
+
+type ProxyConfig struct {
+	Trusted        []string `yaml:"trusted" toml:"trusted" env:"TRUSTED_PROXIES" usage:"comma separated IPs and CIDRs of the proxies allowed to forward the client IP, e.g. 10.0.0.0/8, empty trusts none"`
+	ClientIPHeader string   `yaml:"client_ip_header" toml:"client_ip_header" env:"CLIENT_IP_HEADER" usage:"header the trusted proxies put the client IP in: X-Forwarded-For, X-Real-IP, Forwarded, CF-Connecting-IP or True-Client-IP"`
+	Protocol       bool     `yaml:"protocol" toml:"protocol" env:"PROXY_PROTOCOL" usage:"read the PROXY protocol header sent by the trusted proxies on the HTTP listeners"`
+}
+
+type HealthConfig struct {
+	ReadyTimeout Duration `yaml:"ready_timeout" toml:"ready_timeout" env:"READY_TIMEOUT" usage:"timeout of the readiness database check"`
+	MinFreeDisk  Size     `yaml:"min_free_disk" toml:"min_free_disk" env:"MIN_FREE_DISK" usage:"free space the data dir needs to be ready, 0 disables the check"`
+}
+
+type ShutdownConfig struct {
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"SHUTDOWN_TIMEOUT" usage:"time allowed for in-flight requests to complete on shutdown"`
+	Delay   Duration `yaml:"delay" toml:"delay" env:"SHUTDOWN_DELAY" usage:"time spent reporting unhealthy before closing the listeners on shutdown"`
+}
+
+func Default() Config {
+	return Config{
+		Port:          "8080",
+		Host:          "http://localhost:8080",
+		MaxChainDepth: 5,
+		APIKeyRole:    "editor",
+		JWT: JWTConfig{
+			RefreshInterval: Duration(time.Hour),
+			Algorithms:      []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"},
+			Leeway:          Duration(time.Minute),
+			SubjectClaim:    "sub",
+			RolesClaim:      "roles",
+			ScopesClaim:     "scope",
+			WorkspaceClaim:  "workspace",
+			DefaultRole:     "viewer",
+		},
+		URL: URLConfig{
+			Schemes:   []string{"http", "https"},
+			MaxLength: 2048,
+		},
+		Blocklist: BlocklistConfig{
+			Action:         "reject",
+			ReloadInterval: Duration(time.Minute),
+		},
+		Log: LogConfig{
+			Format:         "console",
+			FileMaxSize:    100,
+			FileMaxBackups: 5,
+		},
+		Database: DatabaseConfig{
+			Dir:                   "./data",
+			ValueLogFileSize:      1 << 30,
+			Compression:           "snappy",
+			BlockCacheSize:        256 << 20,
+			NumCompactors:         4,
+			EncryptionKeyRotation: Duration(10 * 24 * time.Hour),
+		},
+		GC: GCConfig{
+			Interval:     Duration(time.Hour),
+			DiscardRatio: 0.5,
+		},
+		Backup: BackupConfig{
+			Interval:  Duration(24 * time.Hour),
+			Retention: 7,
+		},
+		TLS: TLSConfig{
+			MinVersion: "1.2",
+		},
+		CORS: CORSConfig{
+			Enabled:       true,
+			AllowOrigins:  []string{"*"},
+			AllowMethods:  []string{"GET", "POST", "PUT", "DELETE"},
+			AllowHeaders:  []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "X-Request-ID"},
+			ExposeHeaders: []string{"Content-Length", "Content-Disposition", "X-Request-ID", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			MaxAge:        Duration(12 * time.Hour),
+		},
+		RateLimit: RateLimitConfig{
+			Redirect: Rate{Requests: 300, Period: time.Minute},
+			Create:   Rate{Requests: 30, Period: time.Minute},
+			Admin:    Rate{Requests: 60, Period: time.Minute},
+			Auth:     Rate{Requests: 10, Period: time.Minute},
+		},
+		Proxy: ProxyConfig{
+			ClientIPHeader: "X-Forwarded-For",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{
+			ReadyTimeout: Duration(2 * time.Second),
+			MinFreeDisk:  100 << 20,
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: Duration(30 * time.Second),
+		},
+	}
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the flag and environment variable holding the config file path
+const FileEnv = "CONFIG"
+
+type field struct {
+	value  reflect.Value
+	key    string
+	env    []string
+	usage  string
+	secret bool
+}
+
+// fields walks the leaves of the struct pointed to by v
+func fields(v reflect.Value, prefix string, fn func(f field)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+
+		env := sf.Tag.Get("env")
+		if env == "" {
+			fields(v.Field(i), key+".", fn)
+			continue
+		}
+
+		fn(field{
+			value:  v.Field(i),
+			key:    key,
+			env:    strings.Split(env, ","),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+}
+
+func set(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(s)
+		if e != nil {
+			return fmt.Errorf("invalid boolean: %s", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, e := strconv.Atoi(s)
+		if e != nil {
+			return fmt.Errorf("invalid integer: %s", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, e := strconv.ParseFloat(s, 64)
+		if e != nil {
+			return fmt.Errorf("invalid number: %s", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type: %s", v.Type())
+		}
+		// lists are comma separated in the environment and on the command line
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type: %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	if list, ok := v.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagValue records the raw value of a flag, it is applied only after the
+// file and the environment so that the command line wins
+type flagValue struct {
+	def    string
+	isBool bool
+	value  *string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	f.value = &s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// flagSet defines a flag for every setting of c, named after its first
+// environment variable
+func flagSet(c *Config) (*flag.FlagSet, *string, map[string]*flagValue) {
+	fs := flag.NewFlagSet("curt", flag.ContinueOnError)
+	file := fs.String(FileEnv, os.Getenv(FileEnv), "YAML or TOML config file")
+
+	flags := map[string]*flagValue{}
+	fields(reflect.ValueOf(c).Elem(), "", func(f field) {
+		v := &flagValue{def: format(f.value), isBool: f.value.Kind() == reflect.Bool}
+		if f.secret {
+			v.def = ""
+		}
+		flags[f.env[0]] = v
+		fs.Var(v, f.env[0], f.usage)
+	})
+	return fs, file, flags
+}
+
+// SecretFlags returns the secret settings given in args, which any user can
+// read in the process list
+func SecretFlags(args []string) []string {
+	c := Default()
+	fs, _, flags := flagSet(&c)
+	fs.SetOutput(io.Discard)
+	if fs.Parse(args) != nil {
+		return nil
+	}
+
+	var names []string
+	fields(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		if f.secret && flags[f.env[0]].value != nil {
+			names = append(names, f.env[0])
+		}
+	})
+	return names
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and args, the command line without the program name. It
+// returns the positional arguments left after the flags.
+func Load(args []string) (Config, []string, error) {
+	c := Default()
+
+	fs, file, flags := flagSet(&c)
+	e := fs.Parse(args)
+	if e != nil {
+		return c, nil, e
+	}
+
+	if *file != "" {
+		e = decodeFile(*file, &c)
+		if e != nil {
+			return c, nil, e
+		}
+	}
+
+	var errs []error
+	fields(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		for _, name := range f.env {
+			s, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			e := set(f.value, s)
+			if e != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, e))
+			}
+			break
+		}
+
+		if s := flags[f.env[0]].value; s != nil {
+			e := set(f.value, *s)
+			if e != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.env[0], e))
+			}
+		}
+	})
+
+	return c, fs.Args(), errors.Join(errs...)
+}
+
+// decodeFile reads path into c, by its extension, rejecting unknown keys
+func decodeFile(path string, c *Config) error {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return e
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+		e = d.Decode(c)
+		// an empty file is a valid, if useless, config
+		if errors.Is(e, io.EOF) {
+			e = nil
+		}
+	case ".toml":
+		d := toml.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		e = d.Decode(c)
+		var strict *toml.StrictMissingError
+		if errors.As(e, &strict) {
+			e = errors.New(strict.String())
+		}
+	default:
+		return fmt.Errorf("unknown config file format: %s, must be .yaml, .yml or .toml", path)
+	}
+	if e != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, e)
+	}
+	return nil
+}
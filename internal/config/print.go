@@ -0,0 +1,59 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Redact returns c with the secrets redacted, the names of name:key pairs
+// are kept
+func Redact(c Config) Config {
+	fields(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		if !f.secret || f.value.IsZero() {
+			return
+		}
+		if list, ok := f.value.Interface().([]string); ok {
+			redactedList := make([]string, len(list))
+			for i, item := range list {
+				redactedList[i] = redacted
+				if name, _, found := strings.Cut(item, ":"); found {
+					redactedList[i] = name + ":" + redacted
+				}
+			}
+			f.value.Set(reflect.ValueOf(redactedList))
+			return
+		}
+		f.value.SetString(redacted)
+	})
+	return c
+}
+
+// Print writes c as YAML, in the same layout as the config file, with the
+// secrets redacted
+func Print(w io.Writer, c Config) error {
+	c = Redact(c)
+
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	defer e.Close()
+	return e.Encode(c)
+}
+
+// Settings returns every setting of c by its key in the config file, such
+// as tls.cert_file, and which of them are secrets. Secrets are returned as
+// they are, to be fingerprinted before being stored anywhere
+func Settings(c Config) (map[string]string, map[string]bool) {
+	settings, secrets := map[string]string{}, map[string]bool{}
+	fields(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		settings[f.key] = format(f.value)
+		if f.secret {
+			secrets[f.key] = true
+		}
+	})
+	return settings, secrets
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Size is a number of bytes written with an optional unit, such as 64MiB or 64MB
+type Size int64
+
+func (s *Size) UnmarshalText(text []byte) error {
+	size, e := humanize.ParseBytes(string(text))
+	if e != nil {
+		return fmt.Errorf("invalid size: %s", text)
+	}
+	if size > math.MaxInt64 {
+		return fmt.Errorf("invalid size: %s, too large", text)
+	}
+	*s = Size(size)
+	return nil
+}
+
+// MarshalText uses the largest binary unit that represents s exactly, so
+// that it reads back to the same value
+func (s Size) MarshalText() ([]byte, error) {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	n, i := int64(s), 0
+	for n != 0 && n%1024 == 0 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return []byte(strconv.FormatInt(n, 10)), nil
+	}
+	return []byte(strconv.FormatInt(n, 10) + units[i]), nil
+}
+
+// Duration is a time.Duration written as a string, such as 1h30m
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, e := time.ParseDuration(string(text))
+	if e != nil {
+		return fmt.Errorf("invalid duration: %s", text)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Rate is a number of requests per period, such as 30/1m, 0 means unlimited
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	if string(text) == "0" {
+		*r = Rate{}
+		return nil
+	}
+	requests, period, found := strings.Cut(string(text), "/")
+	n, e := strconv.Atoi(requests)
+	if !found || e != nil || n < 0 {
+		return fmt.Errorf("invalid rate: %s, must be requests/period, e.g. 30/1m", text)
+	}
+	d, e := time.ParseDuration(period)
+	if e != nil || d <= 0 {
+		return fmt.Errorf("invalid rate: %s, must be requests/period, e.g. 30/1m", text)
+	}
+	*r = Rate{Requests: n, Period: d}
+	return nil
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	if r.Requests == 0 {
+		return []byte("0"), nil
+	}
+	return []byte(strconv.Itoa(r.Requests) + "/" + r.Period.String()), nil
+}
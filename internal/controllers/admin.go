@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/rbac"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+const backupVersionHeader = "X-Curt-Backup-Version"
+
+func Admin(g *gin.RouterGroup, r *internal.Resolver) {
+	AdminBackup(g, r)
+	AdminGC(g, r)
+	AdminReloadBlocklists(g, r)
+	AdminAudit(g, r)
+	AdminAuditExport(g, r)
+	AdminWorkspaces(g, r)
+	AdminMoveLinks(g, r)
+	AdminKeys(g, r)
+}
+
+// @Tags admin
+// @Summary Stream a database backup
+// @Description Streams a Badger backup of every entry newer than since. The version to use as since for the next incremental backup is sent in the X-Curt-Backup-Version trailer. With an encryption key the backup is encrypted with it.
+// @Produce  application/octet-stream
+// @Success 200 {file} file
+// @Failure 400,401,403,429,500 {object} models.GenericError
+// @Router /admin/backup [get]
+// @Param since query int false "Only back up entries newer than this version"
+// @Security X-API-Key
+// @Security Bearer
+func AdminBackup(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/backup", middlewares.GinPermissionMiddleware(rbac.ManageDatabase), func(c *gin.Context) {
+		var since uint64
+		if s := c.Query("since"); s != "" {
+			var e error
+			since, e = strconv.ParseUint(s, 10, 64)
+			if e != nil {
+				c.JSON(http.StatusBadRequest,
+					models.GenericError{
+						Message: "invalid since",
+						Details: e.Error(),
+					})
+				return
+			}
+		}
+
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="curt-%s.bak"`, time.Now().UTC().Format("20060102T150405Z")))
+		c.Header("Trailer", backupVersionHeader)
+		c.Status(http.StatusOK)
+
+		version, e := r.Backup(c.Writer, since)
+		if e != nil {
+			// the body is already being streamed, the error can only be logged
+			c.Error(e)
+			return
+		}
+
+		c.Writer.Header().Set(backupVersionHeader, strconv.FormatUint(version, 10))
+		audit(c, r, internal.AuditBackup, gin.H{"since": since, "version": version})
+	})
+}
+
+// @Tags admin
+// @Summary Run the value log GC
+// @Description Rewrites value log files until there is nothing left to reclaim, optionally compacting the LSM tree first
+// @Produce  json
+// @Success 200 {object} models.GC
+// @Failure 400,401,403,409,429,500 {object} models.GenericError
+// @Router /admin/gc [post]
+// @Param flatten query bool false "Compact the whole LSM tree before the GC"
+// @Security X-API-Key
+// @Security Bearer
+func AdminGC(g *gin.RouterGroup, r *internal.Resolver) {
+	g.POST("/gc", middlewares.GinPermissionMiddleware(rbac.ManageDatabase), func(c *gin.Context) {
+		result, e := r.RunGC(c.Query("flatten") == "true")
+		if e == nil {
+			gc := models.GC{
+				Rewrites:       result.Rewrites,
+				ReclaimedBytes: result.ReclaimedBytes,
+				Duration:       result.Duration.String(),
+			}
+			audit(c, r, internal.AuditGC, gc)
+			c.JSON(http.StatusOK, gc)
+			return
+		}
+
+		switch e {
+		case internal.ErrGCRunning:
+			c.JSON(http.StatusConflict,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		case badger.ErrGCInMemoryMode:
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
+
+// @Tags admin
+// @Summary Reload the blocklists
+// @Description Reads the blocklist files again, if any of them is invalid the current rules are kept
+// @Produce  json
+// @Success 200 {object} models.Blocklists
+// @Failure 400,401,403,429,500 {object} models.GenericError
+// @Router /admin/blocklists/reload [post]
+// @Security X-API-Key
+// @Security Bearer
+func AdminReloadBlocklists(g *gin.RouterGroup, r *internal.Resolver) {
+	g.POST("/blocklists/reload", middlewares.GinPermissionMiddleware(rbac.ManageBlocklists), func(c *gin.Context) {
+		stats, e := r.ReloadBlocklists()
+		if e == nil {
+			blocklists := models.Blocklists{
+				Hosts:        stats.Hosts,
+				HashPrefixes: stats.HashPrefixes,
+				Regexps:      stats.Regexps,
+				LoadedAt:     stats.LoadedAt.UTC().Format(time.RFC3339),
+			}
+			audit(c, r, internal.AuditBlocklistsReload, blocklists)
+			c.JSON(http.StatusOK, blocklists)
+			return
+		}
+
+		switch e {
+		case internal.ErrNoBlocklist:
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
+
+// @Tags admin
+// @Summary List the API keys
+// @Description Lists the name, workspace and role of every API key, never the key itself
+// @Produce  json
+// @Success 200 {object} []models.APIKey
+// @Failure 401,403,429 {object} models.GenericError
+// @Router /admin/keys [get]
+// @Security X-API-Key
+// @Security Bearer
+func AdminKeys(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/keys", middlewares.GinPermissionMiddleware(rbac.ManageKeys), func(c *gin.Context) {
+		keys := []models.APIKey{}
+		if apiKeys := r.Auth.APIKeys(); apiKeys.Enabled() {
+			for _, key := range apiKeys.Keys() {
+				workspace := key.Workspace
+				if workspace == "" {
+					workspace = internal.DefaultWorkspace
+				}
+				keys = append(keys, models.APIKey{Name: key.Name, Workspace: workspace, Role: string(key.Role)})
+			}
+		}
+		c.JSON(http.StatusOK, keys)
+	})
+}
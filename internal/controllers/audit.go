@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/rbac"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditEntry returns an entry of action on the link at key, made by the
+// request in c
+func auditEntry(c *gin.Context, action string, d internal.Domain, key string) models.AuditEntry {
+	return models.AuditEntry{
+		Actor:     middlewares.Actor(c),
+		Action:    action,
+		Domain:    d.Name,
+		Key:       key,
+		RequestID: c.GetString(middlewares.RequestIDKey),
+		ClientIP:  c.ClientIP(),
+	}
+}
+
+// audit records an action outside of a link transaction, the action already
+// happened so a failure is only logged
+func audit(c *gin.Context, r *internal.Resolver, action string, after interface{}) {
+	entry := auditEntry(c, action, internal.Domain{}, "")
+	if after != nil {
+		entry.After = internal.AuditJSON(after)
+	}
+	e := r.AuditNow(entry)
+	if e != nil {
+		log.Error().Str("service", "audit").Str("action", action).Err(e).Msg("unable to record the audit entry")
+	}
+}
+
+// auditQuery reads the filters of the audit endpoints
+func auditQuery(c *gin.Context) (internal.AuditQuery, error) {
+	q := internal.AuditQuery{
+		Actor:     c.Query("actor"),
+		Action:    c.Query("action"),
+		Domain:    c.Query("domain"),
+		Key:       c.Query("key"),
+		RequestID: c.Query("requestId"),
+		Limit:     defaultAuditLimit,
+	}
+
+	var e error
+	if s := c.Query("since"); s != "" {
+		q.Since, e = time.Parse(time.RFC3339, s)
+		if e != nil {
+			return q, fmt.Errorf("invalid since: %s, must be RFC 3339", s)
+		}
+	}
+	if s := c.Query("until"); s != "" {
+		q.Until, e = time.Parse(time.RFC3339, s)
+		if e != nil {
+			return q, fmt.Errorf("invalid until: %s, must be RFC 3339", s)
+		}
+	}
+	if s := c.Query("before"); s != "" {
+		q.Before, e = strconv.ParseUint(s, 10, 64)
+		if e != nil {
+			return q, fmt.Errorf("invalid before: %s", s)
+		}
+	}
+	if s := c.Query("limit"); s != "" {
+		q.Limit, e = strconv.Atoi(s)
+		if e != nil || q.Limit < 1 || q.Limit > maxAuditLimit {
+			return q, fmt.Errorf("invalid limit: %s, must be between 1 and %d", s, maxAuditLimit)
+		}
+	}
+	return q, nil
+}
+
+// @Tags admin
+// @Summary Query the audit log
+// @Description Returns the audit entries matching every filter, newest first. The next page is fetched passing next as before.
+// @Produce  json
+// @Success 200 {object} models.AuditLog
+// @Failure 400,401,403,429,500 {object} models.GenericError
+// @Router /admin/audit [get]
+// @Param actor query string false "Key or token that made the change, key:<name> or jwt:<subject>"
+// @Param action query string false "Action, e.g. link.delete, or link for every link action"
+// @Param domain query string false "Domain of the Curt"
+// @Param key query string false "Key of the Curt"
+// @Param requestId query string false "X-Request-ID of the request"
+// @Param since query string false "RFC 3339 time the entries must be newer than"
+// @Param until query string false "RFC 3339 time the entries must be older than"
+// @Param before query string false "ID the entries must be older than"
+// @Param limit query int false "Maximum number of entries, 100 by default, up to 1000"
+// @Security X-API-Key
+// @Security Bearer
+func AdminAudit(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/audit", middlewares.GinPermissionMiddleware(rbac.ReadAudit), func(c *gin.Context) {
+		q, e := auditQuery(c)
+		if e != nil {
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+			return
+		}
+
+		entries, next, e := r.AuditLog(q)
+		if e == nil {
+			auditLog := models.AuditLog{Entries: entries}
+			if next > 0 {
+				auditLog.Next = strconv.FormatUint(next, 10)
+			}
+			c.JSON(http.StatusOK, auditLog)
+			return
+		}
+
+		switch e {
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
+
+// @Tags admin
+// @Summary Export the audit log
+// @Description Streams every audit entry matching the filters, oldest first, as JSON lines or CSV
+// @Produce  application/x-ndjson,text/csv
+// @Success 200 {file} file
+// @Failure 400,401,403,429,500 {object} models.GenericError
+// @Router /admin/audit/export [get]
+// @Param format query string false "jsonl, the default, or csv"
+// @Param actor query string false "Key or token that made the change, key:<name> or jwt:<subject>"
+// @Param action query string false "Action, e.g. link.delete, or link for every link action"
+// @Param domain query string false "Domain of the Curt"
+// @Param key query string false "Key of the Curt"
+// @Param requestId query string false "X-Request-ID of the request"
+// @Param since query string false "RFC 3339 time the entries must be newer than"
+// @Param until query string false "RFC 3339 time the entries must be older than"
+// @Security X-API-Key
+// @Security Bearer
+func AdminAuditExport(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/audit/export", middlewares.GinPermissionMiddleware(rbac.ReadAudit), func(c *gin.Context) {
+		q, e := auditQuery(c)
+		if e != nil {
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+			return
+		}
+
+		filename := "curt-audit-" + time.Now().UTC().Format("20060102T150405Z")
+		var write func(entry models.AuditEntry) error
+		var flush func() error
+
+		switch format := c.DefaultQuery("format", "jsonl"); format {
+		case "jsonl":
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.jsonl"`, filename))
+			encoder := json.NewEncoder(c.Writer)
+			write = func(entry models.AuditEntry) error {
+				return encoder.Encode(entry)
+			}
+			flush = func() error { return nil }
+		case "csv":
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+			w := csv.NewWriter(c.Writer)
+			w.Write([]string{"id", "time", "actor", "action", "domain", "key", "before", "after", "request_id", "client_ip"})
+			write = func(entry models.AuditEntry) error {
+				return w.Write([]string{entry.ID, entry.Time, entry.Actor, entry.Action, entry.Domain, entry.Key, string(entry.Before), string(entry.After), entry.RequestID, entry.ClientIP})
+			}
+			flush = func() error {
+				w.Flush()
+				return w.Error()
+			}
+		default:
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: "invalid format: " + format + ", must be jsonl or csv",
+				})
+			return
+		}
+		c.Status(http.StatusOK)
+
+		e = r.ExportAudit(q, write)
+		if e == nil {
+			e = flush()
+		}
+		if e != nil {
+			// the body is already being streamed, the error can only be logged
+			c.Error(e)
+		}
+	})
+}
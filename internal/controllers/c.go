@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -8,7 +9,10 @@ import (
 	badger "github.com/dgraph-io/badger/v3"
 	"github.com/gin-gonic/gin"
 	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/metrics"
 	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/rbac"
+	"github.com/salvatore-081/curt/internal/tracing"
 	"github.com/salvatore-081/curt/pkg/models"
 	"github.com/teris-io/shortid"
 )
@@ -16,42 +20,62 @@ import (
 func C(g *gin.RouterGroup, r *internal.Resolver) {
 	CGet(g, r)
 	CPost(g, r)
+	CPut(g, r)
 	CGetKey(g, r)
 	CDelete(g, r)
 }
 
+// CAdmin registers every route but the redirect, for when the redirects are
+// served by the public listener
+func CAdmin(g *gin.RouterGroup, r *internal.Resolver) {
+	CGet(g, r)
+	CPost(g, r)
+	CPut(g, r)
+	CDelete(g, r)
+}
+
 // @Tags c
 // @Summary List all Curt(s)
+// @Description Lists the Curt(s) of every domain, or of the given one, in the workspace of the caller. Admins list every workspace, or the given one.
 // @Produce  json
 // @Success 200 {object} []models.Curt
-// @Failure 500 {object} models.GenericError
+// @Failure 400,401,403,429,500 {object} models.GenericError
 // @Router /c [get]
+// @Param domain query string false "Only list the Curt(s) of this domain"
+// @Param workspace query string false "Only list the Curt(s) of this workspace"
 // @Security X-API-Key
+// @Security Bearer
 func CGet(g *gin.RouterGroup, r *internal.Resolver) {
-	g.GET("", middlewares.GinAuthMiddleware(r.XAPIKey), func(c *gin.Context) {
+	g.GET("", middlewares.GinPermissionMiddleware(rbac.ReadLinks), func(c *gin.Context) {
 		curts := []models.Curt{}
 
-		e := r.BadgerDB.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			opts.AllVersions = false
-			opts.PrefetchSize = 10
-			it := txn.NewIterator(opts)
-			defer it.Close()
-			for it.Rewind(); it.Valid(); it.Next() {
-				item := it.Item()
-				var ttl *uint16
-				var expiresAt *uint64
-				if item.ExpiresAt() > 0 {
-					ttl = new(uint16)
-					expiresAt = new(uint64)
-					*expiresAt = item.ExpiresAt()
-					*ttl = uint16(time.Until(time.Unix(int64(*expiresAt), 0)).Hours())
+		domains := r.Domains()
+		if name := c.Query("domain"); name != "" {
+			d, ok := r.Domain(name)
+			if !ok {
+				unknownDomain(c, name)
+				return
+			}
+			domains = []internal.Domain{d}
+		}
+
+		workspace, all := callerWorkspace(c)
+		if name := c.Query("workspace"); name != "" {
+			if !all && name != workspace {
+				forbiddenWorkspace(c, name)
+				return
+			}
+			workspace, all = name, false
+		}
+
+		e := tracing.View(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			for _, d := range domains {
+				var e error
+				if all {
+					e = listDomain(txn, d, r, &curts)
+				} else {
+					e = listWorkspace(txn, d, workspace, r, &curts)
 				}
-				k := item.Key()
-				e := item.Value(func(v []byte) error {
-					curts = append(curts, models.Curt{Url: string(v), Key: string(k), Curt: fmt.Sprintf("%s/c/%s", r.Host, k), TTL: ttl, ExpiresAt: expiresAt})
-					return nil
-				})
 				if e != nil {
 					return e
 				}
@@ -74,16 +98,123 @@ func CGet(g *gin.RouterGroup, r *internal.Resolver) {
 	})
 }
 
+// listDomain appends the Curt(s) of d to curts
+func listDomain(txn *badger.Txn, d internal.Domain, r *internal.Resolver, curts *[]models.Curt) error {
+	opts := badger.DefaultIteratorOptions
+	opts.AllVersions = false
+	opts.PrefetchSize = 10
+	opts.Prefix = d.Prefix()
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if !d.Owns(item.Key()) {
+			continue
+		}
+		e := appendCurt(txn, d, item, r, curts)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// listWorkspace appends the Curt(s) of d in workspace to curts
+func listWorkspace(txn *badger.Txn, d internal.Domain, workspace string, r *internal.Resolver, curts *[]models.Curt) error {
+	return r.WorkspaceLinks(txn, workspace, d, func(storageKey []byte) error {
+		item, e := txn.Get(storageKey)
+		if e == badger.ErrKeyNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		return appendCurt(txn, d, item, r, curts)
+	})
+}
+
+// appendCurt appends the Curt stored in item to curts
+func appendCurt(txn *badger.Txn, d internal.Domain, item *badger.Item, r *internal.Resolver, curts *[]models.Curt) error {
+	var ttl *uint16
+	var expiresAt *uint64
+	if item.ExpiresAt() > 0 {
+		ttl = new(uint16)
+		expiresAt = new(uint64)
+		*expiresAt = item.ExpiresAt()
+		*ttl = uint16(time.Until(time.Unix(int64(*expiresAt), 0)).Hours())
+	}
+	k := d.LinkKey(item.Key())
+	o, e := r.Ownership(txn, item.Key())
+	if e != nil {
+		return e
+	}
+	return item.Value(func(v []byte) error {
+		*curts = append(*curts, models.Curt{Url: string(v), Key: k, Domain: d.Name, Curt: d.Curt(k), TTL: ttl, ExpiresAt: expiresAt, Flagged: item.UserMeta()&internal.MetaFlagged != 0, Owner: o.Owner, Workspace: o.Workspace})
+		return nil
+	})
+}
+
+// callerWorkspace returns the workspace of the request and whether it
+// reaches every workspace
+func callerWorkspace(c *gin.Context) (string, bool) {
+	workspace, all := middlewares.Workspace(c)
+	if workspace == "" {
+		workspace = internal.DefaultWorkspace
+	}
+	return workspace, all
+}
+
+// reachable tells whether the request may see and change the link with
+// ownership o, the links of the other workspaces are answered as not found
+func reachable(c *gin.Context, o internal.Ownership) bool {
+	workspace, all := callerWorkspace(c)
+	return all || o.Workspace == workspace
+}
+
+// forbiddenWorkspace answers a request naming a workspace it can't reach
+func forbiddenWorkspace(c *gin.Context, name string) {
+	c.JSON(http.StatusForbidden,
+		models.GenericError{
+			Message: "forbidden workspace",
+			Details: name,
+		})
+}
+
+// errDenied aborts a transaction on a link the policy doesn't let the
+// request reach
+var errDenied = errors.New("denied")
+
+// invalidTarget answers a request whose url is a Curt that can't be resolved
+func invalidTarget(c *gin.Context, e error) {
+	c.JSON(http.StatusBadRequest,
+		models.ValidationError{
+			Message: "invalid body",
+			Fields:  []models.FieldError{{Field: "url", Message: e.Error()}},
+		})
+}
+
+// unknownDomain answers a request naming a domain that is not configured
+func unknownDomain(c *gin.Context, name string) {
+	c.JSON(http.StatusBadRequest,
+		models.GenericError{
+			Message: "unknown domain",
+			Details: name,
+		})
+}
+
 // @Tags c
 // @Summary Create a new Curt
+// @Description Creates a Curt with the given key, or a generated one, in the workspace of the caller, unless it holds as many links as its quota. Keys used by Curt routes, such as status or swagger, are reserved. A url that is itself a Curt is replaced by the URL it leads to.
 // @Produce  json
-// @Success 200 {object} models.Curt
-// @Failure 400,500 {object} models.GenericError
+// @Success 201 {object} models.Curt
+// @Failure 400 {object} models.ValidationError
+// @Failure 401,403,409,429,500 {object} models.GenericError
 // @Param message body models.Body true "Curt Data"
 // @Router /c [post] models.Body
 // @Security X-API-Key
+// @Security Bearer
 func CPost(g *gin.RouterGroup, r *internal.Resolver) {
-	g.POST("", middlewares.GinAuthMiddleware(r.XAPIKey), func(c *gin.Context) {
+	g.POST("", middlewares.GinPermissionMiddleware(rbac.CreateLinks), func(c *gin.Context) {
 		var body models.Body
 		if e := c.ShouldBindJSON(&body); e != nil {
 			c.JSON(http.StatusBadRequest,
@@ -93,30 +224,100 @@ func CPost(g *gin.RouterGroup, r *internal.Resolver) {
 			return
 		}
 
-		key, e := shortid.Generate()
+		var fields []models.FieldError
+
+		url, e := r.NormalizeURL(body.Url)
 		if e != nil {
-			c.JSON(http.StatusInternalServerError,
-				models.GenericError{
-					Message: e.Error(),
+			fields = append(fields, models.FieldError{Field: "url", Message: e.Error()})
+		}
+
+		d, ok := r.Domain(body.Domain)
+		if !ok {
+			fields = append(fields, models.FieldError{Field: "domain", Message: "unknown domain"})
+		}
+
+		key := body.Key
+		if key != "" {
+			e := r.ValidateKey(key)
+			if e != nil {
+				fields = append(fields, models.FieldError{Field: "key", Message: e.Error()})
+			}
+		}
+
+		if len(fields) > 0 {
+			c.JSON(http.StatusBadRequest,
+				models.ValidationError{
+					Message: "invalid body",
+					Fields:  fields,
 				})
 			return
 		}
 
-		e = r.BadgerDB.Update(func(txn *badger.Txn) error {
-			var entry *badger.Entry
+		if key == "" {
+			key, e = shortid.Generate()
+			if e != nil {
+				c.JSON(http.StatusInternalServerError,
+					models.GenericError{
+						Message: e.Error(),
+					})
+				return
+			}
+		}
+
+		var meta byte
+		workspace, _ := callerWorkspace(c)
+		ownership := internal.Ownership{Owner: middlewares.Owner(c), Workspace: workspace}
+		e = tracing.Update(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			_, e := txn.Get(d.Key(key))
+			if e == nil {
+				return internal.ErrKeyExists
+			}
+			if e != badger.ErrKeyNotFound {
+				return e
+			}
+
+			e = r.CheckQuota(txn, workspace)
+			if e != nil {
+				return e
+			}
+
+			url, e = r.ResolveTarget(txn, url, d.Key(key))
+			if e != nil {
+				return e
+			}
+
+			meta, e = r.ScreenTarget(url)
+			if e != nil {
+				return e
+			}
+
+			entry := badger.NewEntry(d.Key(key), []byte(url)).WithMeta(meta)
 			if body.TTL != nil && *body.TTL > 0 {
-				entry = badger.NewEntry([]byte(key), []byte(body.Url)).WithTTL(time.Hour * time.Duration(*body.TTL))
-			} else {
-				entry = badger.NewEntry([]byte(key), []byte(body.Url))
+				entry = entry.WithTTL(time.Hour * time.Duration(*body.TTL))
 			}
-			e := txn.SetEntry(entry)
-			return e
+			e = txn.SetEntry(entry)
+			if e != nil {
+				return e
+			}
+			e = r.SetOwnership(txn, d.Key(key), ownership, entry.ExpiresAt)
+			if e != nil {
+				return e
+			}
+
+			audit := auditEntry(c, internal.AuditLinkCreate, d, key)
+			audit.After = internal.AuditJSON(internal.NewAuditLink(url, entry.ExpiresAt, meta, ownership))
+			return r.Audit(txn, audit)
 		})
 		if e == nil {
+			metrics.LinksCreated.Inc()
 			curt := models.Curt{
-				Key:  key,
-				Curt: r.Host + "/c/" + key,
-				Url:  body.Url,
+				Key:       key,
+				Domain:    d.Name,
+				Curt:      d.Curt(key),
+				Url:       url,
+				Flagged:   meta&internal.MetaFlagged != 0,
+				Owner:     ownership.Owner,
+				Workspace: ownership.Workspace,
 			}
 			if body.TTL != nil {
 				curt.TTL = body.TTL
@@ -128,6 +329,20 @@ func CPost(g *gin.RouterGroup, r *internal.Resolver) {
 		}
 
 		switch e {
+		case internal.ErrKeyExists:
+			c.JSON(http.StatusConflict,
+				models.GenericError{
+					Message: e.Error(),
+					Details: key,
+				})
+		case internal.ErrQuotaExceeded:
+			c.JSON(http.StatusForbidden,
+				models.GenericError{
+					Message: e.Error(),
+					Details: fmt.Sprintf("%s holds %d links at most", workspace, r.Quota(workspace)),
+				})
+		case internal.ErrRedirectLoop, internal.ErrChainTooDeep, internal.ErrUnknownCurt, internal.ErrURLBlocked:
+			invalidTarget(c, e)
 		default:
 			c.JSON(http.StatusInternalServerError,
 				models.GenericError{
@@ -138,37 +353,221 @@ func CPost(g *gin.RouterGroup, r *internal.Resolver) {
 }
 
 // @Tags c
-// @Summary Delete a Curt
+// @Summary Update the URL of a Curt
+// @Description Replaces the URL of a Curt, a url that is itself a Curt is replaced by the URL it leads to. The expiration is kept unless TTL is set, 0 removes it.
 // @Produce  json
 // @Success 200 {object} models.Curt
-// @Failure 404,500 {object} models.GenericError
-// @Router /c/{key} [delete]
+// @Failure 400 {object} models.ValidationError
+// @Failure 401,403,404,429,500 {object} models.GenericError
 // @Param key path string true "Curt Key"
-func CDelete(g *gin.RouterGroup, r *internal.Resolver) {
-	g.DELETE("/:key", middlewares.GinAuthMiddleware(r.XAPIKey), func(c *gin.Context) {
-		txn := r.BadgerDB.NewTransaction(true)
-		defer txn.Discard()
+// @Param domain query string false "Domain of the Curt, the default one if empty"
+// @Param message body models.UpdateBody true "Curt Data"
+// @Router /c/{key} [put]
+// @Security X-API-Key
+// @Security Bearer
+func CPut(g *gin.RouterGroup, r *internal.Resolver) {
+	g.PUT("/:key", middlewares.GinPermissionMiddleware(rbac.UpdateLinks), func(c *gin.Context) {
+		var body models.UpdateBody
+		if e := c.ShouldBindJSON(&body); e != nil {
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+			return
+		}
 
-		_, e := txn.Get([]byte(c.Param("key")))
+		d, ok := r.Domain(c.Query("domain"))
+		if !ok {
+			unknownDomain(c, c.Query("domain"))
+			return
+		}
+
+		url, e := r.NormalizeURL(body.Url)
 		if e != nil {
+			invalidTarget(c, e)
+			return
+		}
+
+		key := c.Param("key")
+		var expiresAt uint64
+		var meta byte
+		var ownership internal.Ownership
+		var decision rbac.Decision
+		e = tracing.Update(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			item, e := txn.Get(d.Key(key))
+			if e != nil {
+				return e
+			}
+			ownership, e = r.Ownership(txn, d.Key(key))
+			if e != nil {
+				return e
+			}
+			if !reachable(c, ownership) {
+				return badger.ErrKeyNotFound
+			}
+			decision = middlewares.Authorize(c, rbac.UpdateLinks, &rbac.Resource{Owner: ownership.Owner})
+			if !decision.Allowed {
+				return errDenied
+			}
+			previous, e := item.ValueCopy(nil)
+			if e != nil {
+				return e
+			}
+
+			url, e = r.ResolveTarget(txn, url, d.Key(key))
+			if e != nil {
+				return e
+			}
+
+			meta, e = r.ScreenTarget(url)
+			if e != nil {
+				return e
+			}
+
+			expiresAt = item.ExpiresAt()
+			if body.TTL != nil {
+				expiresAt = 0
+				if *body.TTL > 0 {
+					expiresAt = uint64(time.Now().Add(time.Hour * time.Duration(*body.TTL)).Unix())
+				}
+			}
+
+			entry := badger.NewEntry(d.Key(key), []byte(url)).WithMeta(meta)
+			entry.ExpiresAt = expiresAt
+			e = txn.SetEntry(entry)
+			if e != nil {
+				return e
+			}
+			// the link keeps its ownership, which must expire with it
+			e = r.SetOwnership(txn, d.Key(key), ownership, expiresAt)
+			if e != nil {
+				return e
+			}
+
+			audit := auditEntry(c, internal.AuditLinkUpdate, d, key)
+			audit.Before = internal.AuditJSON(internal.NewAuditLink(string(previous), item.ExpiresAt(), item.UserMeta(), ownership))
+			audit.After = internal.AuditJSON(internal.NewAuditLink(url, expiresAt, meta, ownership))
+			return r.Audit(txn, audit)
+		})
+		if e == nil {
+			metrics.LinksUpdated.Inc()
+			curt := models.Curt{
+				Key:       key,
+				Domain:    d.Name,
+				Curt:      d.Curt(key),
+				Url:       url,
+				Flagged:   meta&internal.MetaFlagged != 0,
+				Owner:     ownership.Owner,
+				Workspace: ownership.Workspace,
+			}
+			if expiresAt > 0 {
+				ttl := uint16(time.Until(time.Unix(int64(expiresAt), 0)).Hours())
+				curt.TTL = &ttl
+				curt.ExpiresAt = &expiresAt
+			}
+			c.JSON(http.StatusOK, curt)
+			return
+		}
+
+		switch e {
+		case badger.ErrKeyNotFound:
 			c.JSON(http.StatusNotFound,
 				models.GenericError{
 					Message: "not found",
 					Details: e.Error(),
 				})
+		case errDenied:
+			middlewares.Forbidden(c, decision)
+		case internal.ErrRedirectLoop, internal.ErrChainTooDeep, internal.ErrUnknownCurt, internal.ErrURLBlocked:
+			invalidTarget(c, e)
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
+
+// @Tags c
+// @Summary Delete a Curt
+// @Produce  json
+// @Success 200 {object} models.Curt
+// @Failure 400,401,403,404,429,500 {object} models.GenericError
+// @Router /c/{key} [delete]
+// @Param key path string true "Curt Key"
+// @Param domain query string false "Domain of the Curt, the default one if empty"
+// @Security X-API-Key
+// @Security Bearer
+func CDelete(g *gin.RouterGroup, r *internal.Resolver) {
+	g.DELETE("/:key", middlewares.GinPermissionMiddleware(rbac.DeleteLinks), func(c *gin.Context) {
+		d, ok := r.Domain(c.Query("domain"))
+		if !ok {
+			unknownDomain(c, c.Query("domain"))
+			return
+		}
+
+		_, span := tracing.Tracer.Start(c.Request.Context(), "badger.Update")
+		defer span.End()
+
+		txn := r.BadgerDB.NewTransaction(true)
+		defer txn.Discard()
+
+		item, e := txn.Get(d.Key(c.Param("key")))
+		var ownership internal.Ownership
+		if e == nil {
+			ownership, e = r.Ownership(txn, d.Key(c.Param("key")))
+		}
+		if e == nil && !reachable(c, ownership) {
+			e = badger.ErrKeyNotFound
+		}
+		if e != nil {
+			tracing.SetError(span, e)
+			switch e {
+			case badger.ErrKeyNotFound:
+				c.JSON(http.StatusNotFound,
+					models.GenericError{
+						Message: "not found",
+						Details: e.Error(),
+					})
+			default:
+				c.JSON(http.StatusInternalServerError,
+					models.GenericError{
+						Message: e.Error(),
+					})
+			}
+			return
+		}
+
+		decision := middlewares.Authorize(c, rbac.DeleteLinks, &rbac.Resource{Owner: ownership.Owner})
+		if !decision.Allowed {
+			middlewares.Forbidden(c, decision)
 			return
 		}
 
-		e = txn.Delete([]byte(c.Param("key")))
+		e = txn.Delete(d.Key(c.Param("key")))
+		if e == nil {
+			e = r.DeleteOwnership(txn, d.Key(c.Param("key")))
+		}
+		if e == nil {
+			e = item.Value(func(v []byte) error {
+				audit := auditEntry(c, internal.AuditLinkDelete, d, c.Param("key"))
+				audit.Before = internal.AuditJSON(internal.NewAuditLink(string(v), item.ExpiresAt(), item.UserMeta(), ownership))
+				return r.Audit(txn, audit)
+			})
+		}
 		if e == nil {
 			e = txn.Commit()
 			if e == nil {
+				metrics.LinksDeleted.Inc()
 				c.JSON(http.StatusOK, models.Curt{
-					Key: c.Param("key"),
+					Key:    c.Param("key"),
+					Domain: d.Name,
 				})
 				return
 			}
 		}
+		tracing.SetError(span, e)
 
 		switch e {
 		default:
@@ -182,39 +581,75 @@ func CDelete(g *gin.RouterGroup, r *internal.Resolver) {
 
 // @Tags c
 // @Summary Follow a Curt redirect
+// @Description The key is looked up in the keyspace of the domain in the Host header, unknown hosts use the default domain. With root redirects enabled it is served at /{key} too.
 // @Produce  json
+// @Success 200 {string} string "Warning page, for flagged URLs"
 // @Success 301
-// @Failure 404,500 {object} models.GenericError
+// @Failure 403,404,429,500,508 {object} models.GenericError
 // @Router /c/{key} [get]
 // @Param key path string true "Curt Key"
 func CGetKey(g *gin.RouterGroup, r *internal.Resolver) {
 	g.GET("/:key", func(c *gin.Context) {
-		var v []byte
-		e := r.BadgerDB.View(func(txn *badger.Txn) error {
-			item, e := txn.Get([]byte(c.Param("key")))
+		d := r.DomainForHost(c.Request.Host)
+
+		var target string
+		var meta byte
+		e := tracing.View(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			item, e := txn.Get(d.Key(c.Param("key")))
 			if e != nil {
 				return e
 			}
+			meta = item.UserMeta()
 
-			v, e = item.ValueCopy(nil)
+			v, e := item.ValueCopy(nil)
 			if e != nil {
 				return e
 			}
-			return nil
+
+			// Curt(s) stored before their target was resolved on write may
+			// still point to other Curt(s)
+			target, e = r.ResolveTarget(txn, string(v), d.Key(c.Param("key")))
+			if e == internal.ErrUnknownCurt {
+				target = string(v)
+				return nil
+			}
+			return e
 		})
 
 		if e == nil {
-			c.Redirect(http.StatusMovedPermanently, string(v))
+			blocked, warn := r.ScreenRedirect(target, meta)
+			switch {
+			case blocked:
+				metrics.Redirects.WithLabelValues("blocked").Inc()
+				c.JSON(http.StatusForbidden,
+					models.GenericError{
+						Message: "blocked",
+						Details: "the URL of this Curt has been blocked as potentially harmful",
+					})
+			case warn:
+				metrics.Redirects.WithLabelValues("warned").Inc()
+				interstitial(c, target)
+			default:
+				metrics.Redirects.WithLabelValues("hit").Inc()
+				c.Redirect(http.StatusMovedPermanently, target)
+			}
 			return
 		}
 
 		switch e {
 		case badger.ErrKeyNotFound:
+			metrics.Redirects.WithLabelValues("miss").Inc()
 			c.JSON(http.StatusNotFound,
 				models.GenericError{
 					Message: "not found",
 					Details: e.Error(),
 				})
+		case internal.ErrRedirectLoop, internal.ErrChainTooDeep:
+			metrics.Redirects.WithLabelValues("loop").Inc()
+			c.JSON(http.StatusLoopDetected,
+				models.GenericError{
+					Message: e.Error(),
+				})
 		default:
 			c.JSON(http.StatusInternalServerError,
 				models.GenericError{
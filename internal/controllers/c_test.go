@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+func TestCPostRejectsLoops(t *testing.T) {
+	g, _ := newTestServer(t, testOptions())
+
+	expectStatus(t, serve(g, http.MethodPost, "/c", "", `{"url":"http://localhost:8080/c/self","key":"self"}`), http.StatusBadRequest)
+	expectStatus(t, serve(g, http.MethodPost, "/c", "", `{"url":"http://localhost:8080/c/missing"}`), http.StatusBadRequest)
+
+	expectStatus(t, serve(g, http.MethodPost, "/c", "", `{"url":"https://example.com","key":"a"}`), http.StatusCreated)
+	w := serve(g, http.MethodPost, "/c", "", `{"url":"http://localhost:8080/c/a","key":"b"}`)
+	expectStatus(t, w, http.StatusCreated)
+
+	w = serve(g, http.MethodGet, "/c/b", "", "")
+	expectStatus(t, w, http.StatusMovedPermanently)
+	if location := w.Header().Get("Location"); location != "https://example.com" {
+		t.Errorf("b redirects to %s, want https://example.com", location)
+	}
+}
+
+func TestCGetKeyDetectsStoredLoops(t *testing.T) {
+	g, r := newTestServer(t, testOptions())
+
+	// stored before targets were resolved on write
+	e := r.BadgerDB.Update(func(txn *badger.Txn) error {
+		e := txn.Set([]byte("l1"), []byte("http://localhost:8080/c/l2"))
+		if e != nil {
+			return e
+		}
+		return txn.Set([]byte("l2"), []byte("http://localhost:8080/c/l1"))
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	expectStatus(t, serve(g, http.MethodGet, "/c/l1", "", ""), http.StatusLoopDetected)
+}
+
+func TestCDeleteErrors(t *testing.T) {
+	g, r := newTestServer(t, testOptions())
+
+	expectStatus(t, serve(g, http.MethodDelete, "/c/missing", "", ""), http.StatusNotFound)
+
+	expectStatus(t, serve(g, http.MethodPost, "/c", "", `{"url":"https://example.com","key":"a"}`), http.StatusCreated)
+	// a failing ownership lookup is not a missing link
+	e := r.BadgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("!o/a"), []byte("not json"))
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	expectStatus(t, serve(g, http.MethodDelete, "/c/a", "", ""), http.StatusInternalServerError)
+}
+
+func TestCOwnership(t *testing.T) {
+	o := testOptions()
+	o.Auth = middlewares.AuthProviders{testKeys(t, "editor", []string{"ci:ci", "ops:ops"}, nil)}
+	g, _ := newTestServer(t, o)
+
+	w := serve(g, http.MethodPost, "/c", "ci", `{"url":"https://example.com","key":"a"}`)
+	expectStatus(t, w, http.StatusCreated)
+	var curt models.Curt
+	e := json.Unmarshal(w.Body.Bytes(), &curt)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if curt.Owner != "key:ci" {
+		t.Errorf("got owner %q, want key:ci", curt.Owner)
+	}
+
+	// editors only delete their own links, admins any
+	expectStatus(t, serve(g, http.MethodDelete, "/c/a", "ops", ""), http.StatusForbidden)
+	expectStatus(t, serve(g, http.MethodDelete, "/c/a", "ci", ""), http.StatusOK)
+	expectStatus(t, serve(g, http.MethodPost, "/c", "ci", `{"url":"https://example.com","key":"b"}`), http.StatusCreated)
+	expectStatus(t, serve(g, http.MethodDelete, "/c/b", "root", ""), http.StatusOK)
+}
+
+func TestCPut(t *testing.T) {
+	o := testOptions()
+	o.Auth = middlewares.AuthProviders{testKeys(t, "editor", []string{"ci:ci", "ops:ops", "blog@marketing:blog", "viewer:viewer"}, []string{"viewer:viewer"})}
+	g, r := newTestServer(t, o)
+
+	expectStatus(t, serve(g, http.MethodPost, "/c", "ci", `{"url":"https://example.com","key":"a","TTL":2}`), http.StatusCreated)
+	expectStatus(t, serve(g, http.MethodPost, "/c", "ci", `{"url":"https://example.com","key":"b"}`), http.StatusCreated)
+
+	tests := []struct {
+		name   string
+		key    string
+		path   string
+		body   string
+		status int
+	}{
+		{"editor on its own link", "ci", "/c/a", `{"url":"https://example.org"}`, http.StatusOK},
+		{"editor on someone else's link", "ops", "/c/a", `{"url":"https://evil.com"}`, http.StatusForbidden},
+		{"viewer", "viewer", "/c/a", `{"url":"https://evil.com"}`, http.StatusForbidden},
+		{"other workspace", "blog", "/c/a", `{"url":"https://evil.com"}`, http.StatusNotFound},
+		{"missing link", "ci", "/c/missing", `{"url":"https://example.org"}`, http.StatusNotFound},
+		{"without url", "ci", "/c/a", `{}`, http.StatusBadRequest},
+		{"loop", "ci", "/c/b", `{"url":"http://localhost:8080/c/b"}`, http.StatusBadRequest},
+		{"admin on any link", "root", "/c/b", `{"url":"https://example.net","TTL":0}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serve(g, http.MethodPut, tt.path, tt.key, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+
+	w := serve(g, http.MethodGet, "/c/a", "", "")
+	if location := w.Header().Get("Location"); location != "https://example.org" {
+		t.Errorf("a redirects to %s, want https://example.org", location)
+	}
+
+	// the owner and the expiration are kept
+	w = serve(g, http.MethodPut, "/c/a", "ci", `{"url":"https://example.org/2"}`)
+	expectStatus(t, w, http.StatusOK)
+	var curt models.Curt
+	e := json.Unmarshal(w.Body.Bytes(), &curt)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if curt.Owner != "key:ci" || curt.ExpiresAt == nil {
+		t.Errorf("got %+v, want the owner key:ci and an expiration", curt)
+	}
+
+	entries, _, e := r.AuditLog(internal.AuditQuery{Action: internal.AuditLinkUpdate, Limit: 10})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 3 || entries[0].Actor != "key:ci" || entries[0].Key != "a" {
+		t.Errorf("got audit entries %+v, want the 3 updates", entries)
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/middlewares"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testOptions returns the options of an in-memory resolver, as the defaults
+// of the config give them
+func testOptions() internal.Options {
+	return internal.Options{
+		Host: "http://localhost:8080",
+		Links: internal.LinkOptions{
+			MaxChainDepth: 5,
+		},
+		URLs: internal.URLOptions{
+			Schemes:   []string{"http", "https"},
+			MaxLength: 2048,
+		},
+		Blocklist: internal.BlocklistOptions{
+			Action: internal.BlocklistReject,
+		},
+		Database: internal.DatabaseOptions{
+			InMemory:         true,
+			ValueLogFileSize: 1 << 20,
+			Compression:      "none",
+		},
+		GC: internal.GCOptions{
+			DiscardRatio: 0.5,
+		},
+		Health: internal.HealthOptions{
+			Timeout: time.Second,
+		},
+	}
+}
+
+// testKeys returns API keys named after their key, root is the X_API_KEY
+// admin and the others get defaultRole unless roles says otherwise
+func testKeys(t *testing.T, defaultRole string, pairs []string, roles []string) *middlewares.APIKeys {
+	t.Helper()
+
+	keys, e := middlewares.ParseAPIKeys("root", pairs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = keys.AssignRoles(defaultRole, roles)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return keys
+}
+
+// newTestServer routes the API the way main does, on a single listener
+func newTestServer(t *testing.T, o internal.Options) (*gin.Engine, *internal.Resolver) {
+	t.Helper()
+
+	r := &internal.Resolver{}
+	e := r.Create(o)
+	if e != nil {
+		t.Fatalf("create: %s", e)
+	}
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	g := gin.New()
+	auth := middlewares.GinAuthMiddleware(r.Auth)
+	CGetKey(g.Group("/c"), r)
+	CAdmin(g.Group("/c", auth), r)
+	Status(g.Group("/status"), r)
+	Admin(g.Group("/admin", auth), r)
+	return g, r
+}
+
+// serve sends a request with the X-API-Key key, none if empty
+func serve(g *gin.Engine, method string, path string, key string, body string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, rd)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("got %d %s, want %d %s: %s", w.Code, http.StatusText(w.Code), status, http.StatusText(status), w.Body)
+	}
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Warning: potentially harmful link</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+h1 { color: #b00020; }
+code { word-break: break-all; background: #f4f4f4; padding: 0.2rem 0.4rem; }
+</style>
+</head>
+<body>
+<h1>This link may be harmful</h1>
+<p>The link you followed leads to a page that has been flagged as potentially harmful, for instance phishing or malware.</p>
+<p>Destination: <code>{{.}}</code></p>
+<p><a href="{{.}}" rel="noopener noreferrer nofollow">Continue at your own risk</a></p>
+</body>
+</html>
+`))
+
+// interstitial warns about a flagged target instead of redirecting to it
+func interstitial(c *gin.Context, target string) {
+	var page bytes.Buffer
+	e := interstitialTemplate.Execute(&page, target)
+	if e != nil {
+		c.AbortWithError(http.StatusInternalServerError, e)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
@@ -7,36 +7,103 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/salvatore-081/curt/internal"
 	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/rbac"
 	"github.com/salvatore-081/curt/pkg/models"
 )
 
+// Status registers the probes, which are never authenticated, and the
+// health and about routes, authenticated by a group of their own
 func Status(g *gin.RouterGroup, r *internal.Resolver) {
-	Health(g, r)
-	About(g, r)
+	authenticated := g.Group("", middlewares.GinAuthMiddleware(r.Auth))
+	Health(authenticated, r)
+	Live(g, r)
+	Ready(g, r)
+	About(authenticated, r)
 }
 
 // @Tags status
 // @Summary Health check
 // @Produce  plain/text
 // @Success 200 {string} string	"OK"
-// @Failure 500 {object} models.GenericError
+// @Failure 401,403,503 {object} models.GenericError
 // @Router /status/health [get]
 // @Security X-API-Key
+// @Security Bearer
 func Health(g *gin.RouterGroup, r *internal.Resolver) {
-	g.GET("/health", middlewares.GinAuthMiddleware(r.XAPIKey), func(c *gin.Context) {
+	g.GET("/health", middlewares.GinPermissionMiddleware(rbac.ReadStats), func(c *gin.Context) {
+		if r.Draining() {
+			c.JSON(http.StatusServiceUnavailable,
+				models.GenericError{
+					Message: "shutting down",
+				})
+			return
+		}
+
 		c.JSON(200, "OK")
 	})
 }
 
+// @Tags status
+// @Summary Liveness probe
+// @Description Unauthenticated, succeeds as long as the process is serving requests
+// @Produce  json
+// @Success 200 {string} string	"OK"
+// @Router /status/live [get]
+func Live(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "OK")
+	})
+}
+
+// @Tags status
+// @Summary Readiness probe
+// @Description Unauthenticated, checks that the database answers a read transaction, that the data dir has enough free space and that Curt is not shutting down
+// @Produce  json
+// @Success 200 {object} models.Readiness
+// @Failure 503 {object} models.Readiness
+// @Router /status/ready [get]
+func Ready(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/ready", func(c *gin.Context) {
+		ready, checks := r.Ready(c.Request.Context())
+
+		readiness := models.Readiness{
+			Status:     "ok",
+			Components: map[string]models.Component{},
+		}
+		for _, check := range checks {
+			component := models.Component{
+				Status:  "ok",
+				Details: check.Details,
+			}
+			if !check.Healthy {
+				component.Status = "fail"
+			}
+			if check.Latency > 0 {
+				component.Latency = check.Latency.String()
+			}
+			readiness.Components[check.Name] = component
+		}
+
+		if !ready {
+			readiness.Status = "fail"
+			c.JSON(http.StatusServiceUnavailable, readiness)
+			return
+		}
+
+		c.JSON(http.StatusOK, readiness)
+	})
+}
+
 // @Tags status
 // @Summary About
 // @Produce  json
 // @Success 200 {object} []models.Module
-// @Failure 500 {object} models.GenericError
+// @Failure 401,403,500 {object} models.GenericError
 // @Router /status/about [get]
 // @Security X-API-Key
+// @Security Bearer
 func About(g *gin.RouterGroup, r *internal.Resolver) {
-	g.GET("/about", middlewares.GinAuthMiddleware(r.XAPIKey), func(c *gin.Context) {
+	g.GET("/about", middlewares.GinPermissionMiddleware(rbac.ReadStats), func(c *gin.Context) {
 		info, ok := debug.ReadBuildInfo()
 
 		if !ok {
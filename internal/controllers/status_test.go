@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+func TestProbesWithoutAuth(t *testing.T) {
+	o := testOptions()
+	o.Auth = middlewares.AuthProviders{testKeys(t, "editor", nil, nil)}
+	g, r := newTestServer(t, o)
+
+	expectStatus(t, serve(g, http.MethodGet, "/status/health", "", ""), http.StatusUnauthorized)
+	expectStatus(t, serve(g, http.MethodGet, "/status/live", "", ""), http.StatusOK)
+
+	w := serve(g, http.MethodGet, "/status/ready", "", "")
+	expectStatus(t, w, http.StatusOK)
+	var readiness models.Readiness
+	e := json.Unmarshal(w.Body.Bytes(), &readiness)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if readiness.Status != "ok" || readiness.Components["database"].Status != "ok" {
+		t.Errorf("readiness %+v, want every component ok", readiness)
+	}
+
+	r.Drain()
+	w = serve(g, http.MethodGet, "/status/ready", "", "")
+	expectStatus(t, w, http.StatusServiceUnavailable)
+	e = json.Unmarshal(w.Body.Bytes(), &readiness)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if readiness.Status != "fail" || readiness.Components["shutdown"].Status != "fail" {
+		t.Errorf("readiness %+v, want the shutdown component failing", readiness)
+	}
+	expectStatus(t, serve(g, http.MethodGet, "/status/live", "", ""), http.StatusOK)
+}
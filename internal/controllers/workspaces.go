@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/rbac"
+	"github.com/salvatore-081/curt/internal/tracing"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+// @Tags admin
+// @Summary List the workspaces
+// @Description Lists the workspaces holding links or with a quota, along with their number of links
+// @Produce  json
+// @Success 200 {object} []models.Workspace
+// @Failure 401,403,429,500 {object} models.GenericError
+// @Router /admin/workspaces [get]
+// @Security X-API-Key
+// @Security Bearer
+func AdminWorkspaces(g *gin.RouterGroup, r *internal.Resolver) {
+	g.GET("/workspaces", middlewares.GinPermissionMiddleware(rbac.ManageWorkspaces), func(c *gin.Context) {
+		workspaces := []models.Workspace{}
+		e := tracing.View(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			for name, links := range r.Workspaces(txn) {
+				workspaces = append(workspaces, models.Workspace{Name: name, Links: links, Quota: r.Quota(name)})
+			}
+			return nil
+		})
+		if e == nil {
+			sort.Slice(workspaces, func(i, j int) bool {
+				return workspaces[i].Name < workspaces[j].Name
+			})
+			c.JSON(http.StatusOK, workspaces)
+			return
+		}
+
+		switch e {
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
+
+// @Tags admin
+// @Summary Move Curt(s) to a workspace
+// @Description Moves the Curt(s) with the given keys to the workspace, all of them or none, unless it would hold more links than its quota. Their owner is kept.
+// @Produce  json
+// @Success 200 {object} []models.Curt
+// @Failure 400,401,403,404,429,500 {object} models.GenericError
+// @Param workspace path string true "Workspace to move the Curt(s) to"
+// @Param message body models.MoveBody true "Curt(s) to move"
+// @Router /admin/workspaces/{workspace}/links [post]
+// @Security X-API-Key
+// @Security Bearer
+func AdminMoveLinks(g *gin.RouterGroup, r *internal.Resolver) {
+	g.POST("/workspaces/:workspace/links", middlewares.GinPermissionMiddleware(rbac.ManageWorkspaces), func(c *gin.Context) {
+		var body models.MoveBody
+		if e := c.ShouldBindJSON(&body); e != nil {
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: e.Error(),
+				})
+			return
+		}
+
+		workspace := c.Param("workspace")
+		if !internal.ValidWorkspace(workspace) {
+			c.JSON(http.StatusBadRequest,
+				models.GenericError{
+					Message: internal.ErrInvalidWorkspace.Error(),
+					Details: workspace,
+				})
+			return
+		}
+
+		d, ok := r.Domain(body.Domain)
+		if !ok {
+			unknownDomain(c, body.Domain)
+			return
+		}
+
+		curts := []models.Curt{}
+		var missing string
+		e := tracing.Update(c.Request.Context(), r.BadgerDB, func(txn *badger.Txn) error {
+			moving := 0
+			for _, key := range body.Keys {
+				item, e := txn.Get(d.Key(key))
+				if e == badger.ErrKeyNotFound {
+					missing = key
+				}
+				if e != nil {
+					return e
+				}
+				previous, e := r.Ownership(txn, d.Key(key))
+				if e != nil {
+					return e
+				}
+				ownership := internal.Ownership{Owner: previous.Owner, Workspace: workspace}
+				if previous.Workspace != workspace {
+					moving++
+					e = r.SetOwnership(txn, d.Key(key), ownership, item.ExpiresAt())
+					if e != nil {
+						return e
+					}
+
+					audit := auditEntry(c, internal.AuditLinkMove, d, key)
+					audit.Before = internal.AuditJSON(previous)
+					audit.After = internal.AuditJSON(ownership)
+					e = r.Audit(txn, audit)
+					if e != nil {
+						return e
+					}
+				}
+				curts = append(curts, models.Curt{Key: key, Domain: d.Name, Curt: d.Curt(key), Owner: ownership.Owner, Workspace: workspace})
+			}
+
+			// the moved links are already counted in the new workspace
+			quota := r.Quota(workspace)
+			if moving > 0 && quota > 0 && r.CountLinks(txn, workspace) > quota {
+				return internal.ErrQuotaExceeded
+			}
+			return nil
+		})
+		if e == nil {
+			c.JSON(http.StatusOK, curts)
+			return
+		}
+
+		switch e {
+		case badger.ErrKeyNotFound:
+			c.JSON(http.StatusNotFound,
+				models.GenericError{
+					Message: "not found",
+					Details: missing,
+				})
+		case internal.ErrQuotaExceeded:
+			c.JSON(http.StatusForbidden,
+				models.GenericError{
+					Message: e.Error(),
+					Details: workspace,
+				})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				models.GenericError{
+					Message: e.Error(),
+				})
+		}
+	})
+}
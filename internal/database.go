@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
+	"github.com/dustin/go-humanize"
+	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal/middlewares"
+)
+
+const (
+	minValueLogFileSize = 1 << 20
+	maxValueLogFileSize = 2<<30 - 1
+
+	// fingerprintKeyFile holds the key of the config fingerprints, in the
+	// database dir but out of the database, so that no backup carries it
+	fingerprintKeyFile = "fingerprint.key"
+	fingerprintKeySize = 32
+)
+
+type DatabaseOptions struct {
+	// Dir is ignored when InMemory is set
+	Dir              string
+	InMemory         bool
+	ValueLogFileSize int64
+	SyncWrites       bool
+	// Compression is one of none, snappy or zstd
+	Compression      string
+	BlockCacheSize   int64
+	IndexCacheSize   int64
+	NumCompactors    int
+	CompactL0OnClose bool
+	// EncryptionKey enables AES encryption at rest when set
+	EncryptionKey         []byte
+	EncryptionKeyRotation time.Duration
+}
+
+func compression(s string) (options.CompressionType, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return options.None, nil
+	case "snappy":
+		return options.Snappy, nil
+	case "zstd":
+		return options.ZSTD, nil
+	default:
+		return options.None, fmt.Errorf("unknown compression: %s, must be one of none, snappy, zstd", s)
+	}
+}
+
+func (o DatabaseOptions) validate() error {
+	if !o.InMemory && o.Dir == "" {
+		return fmt.Errorf("missing data dir")
+	}
+	if o.ValueLogFileSize < minValueLogFileSize || o.ValueLogFileSize > maxValueLogFileSize {
+		return fmt.Errorf("invalid value log file size: %d, must be between %s and %s", o.ValueLogFileSize, humanize.IBytes(minValueLogFileSize), humanize.IBytes(maxValueLogFileSize))
+	}
+	if o.BlockCacheSize < 0 {
+		return fmt.Errorf("invalid block cache size: %d, must be 0 or greater", o.BlockCacheSize)
+	}
+	if o.IndexCacheSize < 0 {
+		return fmt.Errorf("invalid index cache size: %d, must be 0 or greater", o.IndexCacheSize)
+	}
+	// badger needs a dedicated L0 compactor plus at least one more, or none at all
+	if o.NumCompactors < 0 || o.NumCompactors == 1 {
+		return fmt.Errorf("invalid number of compactors: %d, must be 0 or at least 2", o.NumCompactors)
+	}
+	e := validateEncryptionKey(o.EncryptionKey)
+	if e != nil {
+		return e
+	}
+	// badger panics on encrypted tables without an index cache
+	if len(o.EncryptionKey) > 0 && o.IndexCacheSize == 0 {
+		return fmt.Errorf("invalid index cache size: 0, encryption needs an index cache, set INDEX_CACHE_SIZE")
+	}
+	if len(o.EncryptionKey) > 0 && o.EncryptionKeyRotation <= 0 {
+		return fmt.Errorf("invalid encryption key rotation: %s, must be greater than 0", o.EncryptionKeyRotation)
+	}
+	c, e := compression(o.Compression)
+	if e != nil {
+		return e
+	}
+	// badger panics without a block cache when blocks are compressed or encrypted
+	if (c != options.None || len(o.EncryptionKey) > 0) && o.BlockCacheSize == 0 {
+		return fmt.Errorf("invalid block cache size: 0, compression and encryption need a block cache")
+	}
+	return nil
+}
+
+func (o DatabaseOptions) badgerOptions() badger.Options {
+	dir := o.Dir
+	if o.InMemory {
+		dir = ""
+	}
+
+	// validate has already rejected unknown values
+	c, _ := compression(o.Compression)
+
+	return badger.DefaultOptions(dir).
+		WithLogger(middlewares.BadgerLogger{}).
+		WithInMemory(o.InMemory).
+		WithValueLogFileSize(o.ValueLogFileSize).
+		WithSyncWrites(o.SyncWrites).
+		WithCompression(c).
+		WithBlockCacheSize(o.BlockCacheSize).
+		WithIndexCacheSize(o.IndexCacheSize).
+		WithNumCompactors(o.NumCompactors).
+		WithCompactL0OnClose(o.CompactL0OnClose).
+		WithEncryptionKey(o.EncryptionKey).
+		WithEncryptionKeyRotationDuration(o.EncryptionKeyRotation)
+}
+
+// fingerprintKey returns the key the secrets of the config are fingerprinted
+// with, generated on the first start and then read from the database dir.
+// An in-memory database gets a new one on every start
+func (o DatabaseOptions) fingerprintKey() ([]byte, error) {
+	path := filepath.Join(o.Dir, fingerprintKeyFile)
+	if !o.InMemory {
+		key, e := os.ReadFile(path)
+		switch {
+		case e == nil && len(key) == fingerprintKeySize:
+			return key, nil
+		case e == nil:
+			return nil, fmt.Errorf("invalid fingerprint key %s, must be %d bytes", path, fingerprintKeySize)
+		case !errors.Is(e, os.ErrNotExist):
+			return nil, e
+		}
+	}
+
+	key := make([]byte, fingerprintKeySize)
+	_, e := rand.Read(key)
+	if e != nil {
+		return nil, e
+	}
+	if o.InMemory {
+		return key, nil
+	}
+	return key, os.WriteFile(path, key, 0o600)
+}
+
+func (o DatabaseOptions) log() {
+	event := log.Info().Str("service", "badgerDB")
+	if o.InMemory {
+		event = event.Bool("in_memory", true)
+	} else {
+		event = event.Str("dir", o.Dir)
+	}
+	event.
+		Str("value_log_file_size", humanize.IBytes(uint64(o.ValueLogFileSize))).
+		Bool("sync_writes", o.SyncWrites).
+		Str("compression", strings.ToLower(o.Compression)).
+		Str("block_cache_size", humanize.IBytes(uint64(o.BlockCacheSize))).
+		Str("index_cache_size", humanize.IBytes(uint64(o.IndexCacheSize))).
+		Int("num_compactors", o.NumCompactors).
+		Bool("compact_l0_on_close", o.CompactL0OnClose).
+		Bool("encrypted", len(o.EncryptionKey) > 0).
+		Msg("database options")
+}
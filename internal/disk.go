@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package internal
+
+import "syscall"
+
+// freeDiskSpace returns the bytes available to unprivileged users on the filesystem holding path
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	e := syscall.Statfs(path, &stat)
+	if e != nil {
+		return 0, e
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
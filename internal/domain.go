@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// internalKeyPrefix starts every key that is not a link of the default
+// domain, shortid keys never contain it
+const internalKeyPrefix = "!"
+
+// domainKeyPrefix namespaces the links of the additional domains, the ones
+// of the default domain are stored unprefixed, as before domains existed,
+// so that changing HOST does not orphan them
+const domainKeyPrefix = internalKeyPrefix + "d/"
+
+// Domain is a short domain, with its own keyspace
+type Domain struct {
+	// Name is the lowercase hostname matched against the Host header
+	Name string
+	// URL is the base URL of the Curt(s)
+	URL string
+	// path is the path of the Curt(s) before the key
+	path string
+	// redirectPaths are the paths before the key of every redirect route
+	redirectPaths []string
+	prefix        []byte
+	Default       bool
+}
+
+func parseDomain(base string) (Domain, error) {
+	u, e := url.Parse(base)
+	if e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.User != nil {
+		return Domain{}, fmt.Errorf("invalid domain: %s, must be an http(s) URL", base)
+	}
+	return Domain{
+		Name: strings.ToLower(u.Hostname()),
+		URL:  strings.TrimSuffix(base, "/"),
+	}, nil
+}
+
+func (d *Domain) setPaths(o LinkOptions) {
+	d.path = o.path()
+
+	u, _ := url.Parse(d.URL)
+	d.redirectPaths = []string{u.Path + o.BasePath + "/c/"}
+	if o.RootRedirects {
+		d.redirectPaths = append(d.redirectPaths, u.Path+o.BasePath+"/")
+	}
+}
+
+// Key returns the storage key of the link key
+func (d Domain) Key(key string) []byte {
+	return append(append([]byte{}, d.prefix...), key...)
+}
+
+// Prefix returns the prefix of every storage key of the domain, the default
+// domain has none, so its internal keys must be skipped with Owns
+func (d Domain) Prefix() []byte {
+	return d.prefix
+}
+
+// Owns tells whether the storage key is a link of d
+func (d Domain) Owns(storageKey []byte) bool {
+	if d.Default {
+		return !bytes.HasPrefix(storageKey, []byte(internalKeyPrefix))
+	}
+	return bytes.HasPrefix(storageKey, d.prefix)
+}
+
+// LinkKey strips the domain prefix from a storage key owned by d
+func (d Domain) LinkKey(storageKey []byte) string {
+	return string(storageKey[len(d.prefix):])
+}
+
+// Curt returns the short URL of key
+func (d Domain) Curt(key string) string {
+	return d.URL + d.path + key
+}
+
+// setDomains builds the default domain from host and the additional ones
+// from domains, each a base URL such as https://brand.ly
+func (r *Resolver) setDomains(host string, domains []string, o LinkOptions) error {
+	d, e := parseDomain(host)
+	if e != nil {
+		return fmt.Errorf("invalid host: %s, must be an http(s) URL", host)
+	}
+	d.Default = true
+	d.setPaths(o)
+	r.domains = []Domain{d}
+
+	seen := map[string]bool{d.Name: true}
+	for _, base := range domains {
+		d, e := parseDomain(base)
+		if e != nil {
+			return e
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("duplicate domain: %s", d.Name)
+		}
+		seen[d.Name] = true
+		d.setPaths(o)
+		d.prefix = []byte(domainKeyPrefix + d.Name + "/")
+		r.domains = append(r.domains, d)
+	}
+	return nil
+}
+
+// Domains returns every domain, the default one first
+func (r *Resolver) Domains() []Domain {
+	return r.domains
+}
+
+// Domain looks up a domain by name, an empty name is the default domain
+func (r *Resolver) Domain(name string) (Domain, bool) {
+	if name == "" {
+		return r.domains[0], true
+	}
+	name = strings.ToLower(name)
+	for _, d := range r.domains {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Domain{}, false
+}
+
+// DomainForHost returns the domain serving the Host header host, falling
+// back to the default domain for unknown hosts, e.g. an IP address
+func (r *Resolver) DomainForHost(host string) Domain {
+	if h, _, e := net.SplitHostPort(host); e == nil {
+		host = h
+	}
+	d, ok := r.Domain(host)
+	if !ok || host == "" {
+		return r.domains[0]
+	}
+	return d
+}
@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ReadEncryptionKey reads a key from a file or secret mount, ignoring
+// the trailing newline most editors and `echo` add
+func ReadEncryptionKey(path string) ([]byte, error) {
+	key, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	return bytes.TrimRight(key, "\r\n"), nil
+}
+
+func validateEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 0, 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid encryption key: must be 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256, got %d bytes", len(key))
+	}
+}
+
+// openError turns the badger errors caused by the encryption key into
+// something the operator can act upon
+func openError(e error, key []byte) error {
+	if !errors.Is(e, badger.ErrEncryptionKeyMismatch) {
+		return e
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("the database is encrypted but no encryption key was given, set ENCRYPTION_KEY or ENCRYPTION_KEY_FILE: %w", e)
+	}
+	return fmt.Errorf("the encryption key does not match the database, either the key is wrong or the database is not encrypted: %w", e)
+}
+
+// RotateEncryptionKey re-encrypts the key registry with newKey, the data keys
+// and so the data itself are left as they are. Curt must be stopped while it runs.
+func RotateEncryptionKey(o DatabaseOptions, newKey []byte) error {
+	if o.InMemory {
+		return fmt.Errorf("an in memory database has no encryption key to rotate")
+	}
+	if len(o.EncryptionKey) == 0 {
+		return fmt.Errorf("missing current encryption key, set ENCRYPTION_KEY or ENCRYPTION_KEY_FILE")
+	}
+	if len(newKey) == 0 {
+		return fmt.Errorf("missing new encryption key")
+	}
+
+	e := validateEncryptionKey(newKey)
+	if e != nil {
+		return e
+	}
+
+	// opening the database checks the current key and fails if Curt is still
+	// running, since badger holds a lock on the directory
+	db, e := badger.Open(o.badgerOptions())
+	if e != nil {
+		return openError(e, o.EncryptionKey)
+	}
+	e = db.Close()
+	if e != nil {
+		return e
+	}
+
+	opt := badger.KeyRegistryOptions{
+		Dir:                           o.Dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 o.EncryptionKey,
+		EncryptionKeyRotationDuration: o.EncryptionKeyRotation,
+	}
+	registry, e := badger.OpenKeyRegistry(opt)
+	if e != nil {
+		return openError(e, o.EncryptionKey)
+	}
+	defer registry.Close()
+
+	opt.EncryptionKey = newKey
+	return badger.WriteKeyRegistry(registry, opt)
+}
+
+// encryptedBackupMagic starts the backups of an encrypted database, a plain
+// badger backup starts with the little endian length of its first batch,
+// which is never this large
+var encryptedBackupMagic = []byte("CURTENC1")
+
+const (
+	// backupChunkSize is the plaintext size of every chunk but the last one
+	backupChunkSize = 64 << 10
+	// backupFrameSize is the flag and length before each sealed chunk
+	backupFrameSize = 5
+	lastChunk       = 1
+)
+
+var (
+	ErrBackupEncrypted   = errors.New("the backup is encrypted, set the ENCRYPTION_KEY or ENCRYPTION_KEY_FILE of the database it was taken from")
+	ErrBackupKeyMismatch = errors.New("unable to decrypt the backup, either the encryption key is wrong or the backup was altered")
+	ErrBackupTruncated   = errors.New("the encrypted backup is truncated")
+)
+
+// backupAEAD returns the AES-256-GCM cipher of the backups, keyed by a key
+// derived from the encryption key, so that the same key never encrypts both
+// the database and its backups
+func backupAEAD(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("curt backup encryption"))
+	block, e := aes.NewCipher(mac.Sum(nil))
+	if e != nil {
+		return nil, e
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce is the random prefix of the backup, the chunk counter and the
+// last chunk flag, so that chunks can't be reordered, dropped or appended
+func backupNonce(prefix []byte, counter uint32, flag byte) []byte {
+	nonce := make([]byte, 0, len(prefix)+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	return append(nonce, flag)
+}
+
+// backupEncrypter seals a backup stream in chunks, Close seals the last one
+type backupEncrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newBackupEncrypter(w io.Writer, key []byte) (*backupEncrypter, error) {
+	aead, e := backupAEAD(key)
+	if e != nil {
+		return nil, e
+	}
+	prefix := make([]byte, aead.NonceSize()-5)
+	_, e = rand.Read(prefix)
+	if e != nil {
+		return nil, e
+	}
+
+	header := append(append([]byte{}, encryptedBackupMagic...), prefix...)
+	_, e = w.Write(header)
+	if e != nil {
+		return nil, e
+	}
+	return &backupEncrypter{w: w, aead: aead, header: header, prefix: prefix}, nil
+}
+
+func (b *backupEncrypter) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	for len(b.buf) > backupChunkSize {
+		e := b.seal(b.buf[:backupChunkSize], 0)
+		if e != nil {
+			return 0, e
+		}
+		b.buf = b.buf[backupChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the buffered data as the last chunk, it doesn't close w
+func (b *backupEncrypter) Close() error {
+	return b.seal(b.buf, lastChunk)
+}
+
+func (b *backupEncrypter) seal(chunk []byte, flag byte) error {
+	if b.counter == math.MaxUint32 {
+		return fmt.Errorf("backup too large to encrypt")
+	}
+	sealed := b.aead.Seal(nil, backupNonce(b.prefix, b.counter, flag), chunk, b.header)
+	b.counter++
+
+	frame := make([]byte, backupFrameSize)
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+	_, e := b.w.Write(frame)
+	if e != nil {
+		return e
+	}
+	_, e = b.w.Write(sealed)
+	return e
+}
+
+// backupDecrypter opens the chunks sealed by backupEncrypter, failing
+// unless the stream ends with the last chunk
+type backupDecrypter struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	done    bool
+}
+
+func newBackupDecrypter(r io.Reader, key []byte) (*backupDecrypter, error) {
+	aead, e := backupAEAD(key)
+	if e != nil {
+		return nil, e
+	}
+	header := make([]byte, len(encryptedBackupMagic)+aead.NonceSize()-5)
+	_, e = io.ReadFull(r, header)
+	if e != nil {
+		return nil, ErrBackupTruncated
+	}
+	return &backupDecrypter{r: r, aead: aead, header: header, prefix: header[len(encryptedBackupMagic):]}, nil
+}
+
+func (b *backupDecrypter) Read(p []byte) (int, error) {
+	for len(b.plain) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		e := b.open()
+		if e != nil {
+			return 0, e
+		}
+	}
+	n := copy(p, b.plain)
+	b.plain = b.plain[n:]
+	return n, nil
+}
+
+func (b *backupDecrypter) open() error {
+	frame := make([]byte, backupFrameSize)
+	_, e := io.ReadFull(b.r, frame)
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		return ErrBackupTruncated
+	}
+	if e != nil {
+		return e
+	}
+
+	flag := frame[0]
+	size := binary.BigEndian.Uint32(frame[1:])
+	if flag > lastChunk || size > uint32(backupChunkSize+b.aead.Overhead()) {
+		return ErrBackupKeyMismatch
+	}
+	sealed := make([]byte, size)
+	_, e = io.ReadFull(b.r, sealed)
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		return ErrBackupTruncated
+	}
+	if e != nil {
+		return e
+	}
+
+	b.plain, e = b.aead.Open(sealed[:0], backupNonce(b.prefix, b.counter, flag), sealed, b.header)
+	if e != nil {
+		return ErrBackupKeyMismatch
+	}
+	b.counter++
+
+	if flag == lastChunk {
+		b.done = true
+		// nothing may follow the last chunk
+		n, _ := io.ReadFull(b.r, make([]byte, 1))
+		if n > 0 {
+			return ErrBackupKeyMismatch
+		}
+	}
+	return nil
+}
+
+// decryptBackup returns the plain stream of the backup in rd, plain backups
+// are returned as they are, so that they can be loaded into an encrypted
+// database
+func decryptBackup(rd io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(rd)
+	head, _ := br.Peek(len(encryptedBackupMagic))
+	if !bytes.Equal(head, encryptedBackupMagic) {
+		return br, nil
+	}
+	if len(key) == 0 {
+		return nil, ErrBackupEncrypted
+	}
+	return newBackupDecrypter(br, key)
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey      = []byte("0123456789abcdef0123456789abcdef")
+	otherTestKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// encryptedOptions returns the options of an encrypted database in dir
+func encryptedOptions(dir string, key []byte) Options {
+	o := testOptions()
+	o.Database.InMemory = false
+	o.Database.Dir = dir
+	o.Database.EncryptionKey = key
+	o.Database.EncryptionKeyRotation = 10 * 24 * time.Hour
+	o.Database.BlockCacheSize = 1 << 20
+	o.Database.IndexCacheSize = 1 << 20
+	return o
+}
+
+func encryptBackup(t *testing.T, plain []byte, key []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	ew, e := newBackupEncrypter(&b, key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// uneven writes, as badger sends them
+	for len(plain) > 0 {
+		n := 1000
+		if n > len(plain) {
+			n = len(plain)
+		}
+		_, e = ew.Write(plain[:n])
+		if e != nil {
+			t.Fatal(e)
+		}
+		plain = plain[n:]
+	}
+	e = ew.Close()
+	if e != nil {
+		t.Fatal(e)
+	}
+	return b.Bytes()
+}
+
+func TestValidateEncryptionKey(t *testing.T) {
+	tests := []struct {
+		size  int
+		valid bool
+	}{
+		{0, true},
+		{8, false},
+		{16, true},
+		{24, true},
+		{32, true},
+		{33, false},
+	}
+	for _, tt := range tests {
+		e := validateEncryptionKey(make([]byte, tt.size))
+		if (e == nil) != tt.valid {
+			t.Errorf("validateEncryptionKey(%d bytes) = %v, want valid %t", tt.size, e, tt.valid)
+		}
+	}
+}
+
+func TestBackupEncryptionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, backupChunkSize - 1, backupChunkSize, backupChunkSize + 1, 3*backupChunkSize + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		encrypted := encryptBackup(t, plain, testKey)
+		if !bytes.HasPrefix(encrypted, encryptedBackupMagic) {
+			t.Fatalf("%d bytes: encrypted backup doesn't start with the magic", size)
+		}
+		if size > 64 && bytes.Contains(encrypted, plain[:64]) {
+			t.Errorf("%d bytes: encrypted backup holds the plaintext", size)
+		}
+
+		rd, e := decryptBackup(bytes.NewReader(encrypted), testKey)
+		if e != nil {
+			t.Fatalf("%d bytes: %s", size, e)
+		}
+		got, e := io.ReadAll(rd)
+		if e != nil {
+			t.Fatalf("%d bytes: %s", size, e)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted %d bytes that differ from the plaintext", size, len(got))
+		}
+	}
+}
+
+func TestDecryptBackupRejects(t *testing.T) {
+	plain := make([]byte, 2*backupChunkSize+100)
+	rand.Read(plain)
+	encrypted := encryptBackup(t, plain, testKey)
+	headerSize := len(encryptedBackupMagic) + 7
+	// the last chunk seals the 100 bytes left with a 16 bytes tag
+	lastFrame := len(encrypted) - backupFrameSize - 100 - 16
+
+	flipped := append([]byte{}, encrypted...)
+	flipped[headerSize+backupFrameSize+10] ^= 1
+
+	// the second chunk removed, the first and last one still chained
+	secondFrame := headerSize + backupFrameSize + backupChunkSize + 16
+	dropped := append(append([]byte{}, encrypted[:secondFrame]...), encrypted[lastFrame:]...)
+
+	tests := []struct {
+		name string
+		data []byte
+		key  []byte
+		want error
+	}{
+		{"missing key", encrypted, nil, ErrBackupEncrypted},
+		{"wrong key", encrypted, otherTestKey, ErrBackupKeyMismatch},
+		{"altered chunk", flipped, testKey, ErrBackupKeyMismatch},
+		{"dropped chunk", dropped, testKey, ErrBackupKeyMismatch},
+		{"missing last chunk", encrypted[:lastFrame], testKey, ErrBackupTruncated},
+		{"cut chunk", encrypted[:len(encrypted)-10], testKey, ErrBackupTruncated},
+		{"cut header", encrypted[:headerSize-1], testKey, ErrBackupTruncated},
+		{"trailing data", append(append([]byte{}, encrypted...), 0), testKey, ErrBackupKeyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd, e := decryptBackup(bytes.NewReader(tt.data), tt.key)
+			if e == nil {
+				_, e = io.ReadAll(rd)
+			}
+			if !errors.Is(e, tt.want) {
+				t.Errorf("got %v, want %v", e, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptPlainBackup(t *testing.T) {
+	plain := []byte("a plain badger backup")
+	for _, key := range [][]byte{nil, testKey} {
+		rd, e := decryptBackup(bytes.NewReader(plain), key)
+		if e != nil {
+			t.Fatal(e)
+		}
+		got, _ := io.ReadAll(rd)
+		if !bytes.Equal(got, plain) {
+			t.Errorf("with key %q got %q, want the plain backup as it is", key, got)
+		}
+	}
+}
+
+func TestEncryptedDatabaseBackup(t *testing.T) {
+	source := newTestResolver(t, encryptedOptions(t.TempDir(), testKey))
+	setLinks(t, source, map[string]string{"abc": "https://example.com/?token=secret"})
+
+	var backup bytes.Buffer
+	_, e := source.Backup(&backup, 0)
+	if e != nil {
+		t.Fatalf("backup: %s", e)
+	}
+	if bytes.Contains(backup.Bytes(), []byte("token=secret")) {
+		t.Fatal("the backup of an encrypted database holds the links in plaintext")
+	}
+
+	plain := newTestResolver(t, testOptions())
+	e = plain.Restore(bytes.NewReader(backup.Bytes()))
+	if !errors.Is(e, ErrBackupEncrypted) {
+		t.Errorf("restoring into a plain database: got %v, want %v", e, ErrBackupEncrypted)
+	}
+
+	other := newTestResolver(t, encryptedOptions(t.TempDir(), otherTestKey))
+	e = other.Restore(bytes.NewReader(backup.Bytes()))
+	if !errors.Is(e, ErrBackupKeyMismatch) {
+		t.Errorf("restoring with another key: got %v, want %v", e, ErrBackupKeyMismatch)
+	}
+
+	target := newTestResolver(t, encryptedOptions(t.TempDir(), testKey))
+	e = target.Restore(bytes.NewReader(backup.Bytes()))
+	if e != nil {
+		t.Fatalf("restore: %s", e)
+	}
+	if got := getLink(t, target, "abc"); got != "https://example.com/?token=secret" {
+		t.Errorf("abc = %q, want https://example.com/?token=secret", got)
+	}
+}
+
+func TestPlainBackupIntoEncryptedDatabase(t *testing.T) {
+	source := newTestResolver(t, testOptions())
+	setLinks(t, source, map[string]string{"abc": "https://example.com"})
+	var backup bytes.Buffer
+	_, e := source.Backup(&backup, 0)
+	if e != nil {
+		t.Fatalf("backup: %s", e)
+	}
+
+	target := newTestResolver(t, encryptedOptions(t.TempDir(), testKey))
+	e = target.Restore(&backup)
+	if e != nil {
+		t.Fatalf("restore: %s", e)
+	}
+	if got := getLink(t, target, "abc"); got != "https://example.com" {
+		t.Errorf("abc = %q, want https://example.com", got)
+	}
+}
+
+func TestOpenWithWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	r := &Resolver{}
+	e := r.Create(encryptedOptions(dir, testKey))
+	if e != nil {
+		t.Fatal(e)
+	}
+	r.Close()
+
+	tests := []struct {
+		name string
+		o    Options
+		want string
+	}{
+		{"wrong key", encryptedOptions(dir, otherTestKey), "does not match"},
+		{"missing key", func() Options {
+			o := testOptions()
+			o.Database.InMemory = false
+			o.Database.Dir = dir
+			return o
+		}(), "no encryption key was given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resolver{}
+			e := r.Create(tt.o)
+			if e == nil {
+				r.Close()
+				t.Fatal("opened the database")
+			}
+			if !strings.Contains(e.Error(), tt.want) {
+				t.Errorf("got %q, want it to contain %q", e, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	r := &Resolver{}
+	e := r.Create(encryptedOptions(dir, testKey))
+	if e != nil {
+		t.Fatal(e)
+	}
+	setLinks(t, r, map[string]string{"abc": "https://example.com"})
+	r.Close()
+
+	e = RotateEncryptionKey(encryptedOptions(dir, otherTestKey).Database, testKey)
+	if e == nil {
+		t.Error("rotated with the wrong current key")
+	}
+
+	e = RotateEncryptionKey(encryptedOptions(dir, testKey).Database, otherTestKey)
+	if e != nil {
+		t.Fatalf("rotate: %s", e)
+	}
+
+	old := &Resolver{}
+	e = old.Create(encryptedOptions(dir, testKey))
+	if e == nil {
+		old.Close()
+		t.Fatal("opened with the old key after the rotation")
+	}
+
+	rotated := newTestResolver(t, encryptedOptions(dir, otherTestKey))
+	if got := getLink(t, rotated, "abc"); got != "https://example.com" {
+		t.Errorf("abc = %q, want https://example.com", got)
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/salvatore-081/curt/internal/tracing"
+)
+
+type HealthOptions struct {
+	// Timeout bounds the database check
+	Timeout time.Duration
+	// MinFreeDisk is the free space the data dir must have to be ready, 0 disables the check
+	MinFreeDisk uint64
+}
+
+type Check struct {
+	Name    string
+	Healthy bool
+	Details string
+	Latency time.Duration
+}
+
+var errFreeDiskSpaceUnsupported = errors.New("free disk space check not supported on this platform")
+
+// healthProbeKey is read, and never written, to exercise the read path
+var healthProbeKey = []byte("!health")
+
+func (o HealthOptions) validate() error {
+	if o.Timeout <= 0 {
+		return fmt.Errorf("invalid readiness timeout: %s, must be greater than 0", o.Timeout)
+	}
+	return nil
+}
+
+// Ready runs every readiness check, it is ready only if all of them are healthy
+func (r *Resolver) Ready(ctx context.Context) (ready bool, checks []Check) {
+	checks = []Check{
+		r.checkShutdown(),
+		r.checkDatabase(ctx),
+		r.checkDisk(),
+	}
+
+	ready = true
+	for _, check := range checks {
+		ready = ready && check.Healthy
+	}
+	return ready, checks
+}
+
+func (r *Resolver) checkShutdown() Check {
+	if r.Draining() {
+		return Check{Name: "shutdown", Details: "shutting down"}
+	}
+	return Check{Name: "shutdown", Healthy: true}
+}
+
+func (r *Resolver) checkDatabase(ctx context.Context) Check {
+	ctx, cancel := context.WithTimeout(ctx, r.health.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- tracing.View(ctx, r.BadgerDB, func(txn *badger.Txn) error {
+			_, e := txn.Get(healthProbeKey)
+			if errors.Is(e, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return e
+		})
+	}()
+
+	select {
+	case e := <-done:
+		if e != nil {
+			return Check{Name: "database", Details: e.Error(), Latency: time.Since(start)}
+		}
+		return Check{Name: "database", Healthy: true, Latency: time.Since(start)}
+	case <-ctx.Done():
+		return Check{Name: "database", Details: fmt.Sprintf("read transaction timed out after %s", r.health.Timeout), Latency: time.Since(start)}
+	}
+}
+
+func (r *Resolver) checkDisk() Check {
+	opts := r.BadgerDB.Opts()
+	if r.health.MinFreeDisk == 0 || opts.InMemory {
+		return Check{Name: "disk", Healthy: true, Details: "check disabled"}
+	}
+
+	free, e := freeDiskSpace(opts.Dir)
+	if errors.Is(e, errFreeDiskSpaceUnsupported) {
+		return Check{Name: "disk", Healthy: true, Details: e.Error()}
+	}
+	if e != nil {
+		return Check{Name: "disk", Details: e.Error()}
+	}
+
+	details := fmt.Sprintf("%s free, %s required", humanize.IBytes(free), humanize.IBytes(r.health.MinFreeDisk))
+	return Check{Name: "disk", Healthy: free >= r.health.MinFreeDisk, Details: details}
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// checks returns the checks of r by name
+func checks(r *Resolver) (bool, map[string]Check) {
+	ready, list := r.Ready(context.Background())
+	byName := map[string]Check{}
+	for _, check := range list {
+		byName[check.Name] = check
+	}
+	return ready, byName
+}
+
+func TestReady(t *testing.T) {
+	r := newTestResolver(t, testOptions())
+
+	ready, byName := checks(r)
+	if !ready {
+		t.Fatalf("not ready: %+v", byName)
+	}
+	for _, name := range []string{"shutdown", "database", "disk"} {
+		if !byName[name].Healthy {
+			t.Errorf("%s check failed: %s", name, byName[name].Details)
+		}
+	}
+	if byName["database"].Latency <= 0 {
+		t.Error("the database check reported no latency")
+	}
+}
+
+func TestReadyWhileDraining(t *testing.T) {
+	r := newTestResolver(t, testOptions())
+	r.Drain()
+
+	ready, byName := checks(r)
+	if ready || byName["shutdown"].Healthy {
+		t.Errorf("ready while draining: %+v", byName)
+	}
+	if !byName["database"].Healthy {
+		t.Errorf("the database check failed while draining: %s", byName["database"].Details)
+	}
+}
+
+func TestReadyDiskSpace(t *testing.T) {
+	tests := []struct {
+		name        string
+		minFreeDisk uint64
+		healthy     bool
+	}{
+		{"disabled", 0, true},
+		{"enough", 1, true},
+		{"not enough", math.MaxUint64, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions()
+			o.Database.InMemory = false
+			o.Database.Dir = t.TempDir()
+			o.Health.MinFreeDisk = tt.minFreeDisk
+			r := newTestResolver(t, o)
+
+			ready, byName := checks(r)
+			if ready != tt.healthy || byName["disk"].Healthy != tt.healthy {
+				t.Errorf("ready %t, disk check %+v, want healthy %t", ready, byName["disk"], tt.healthy)
+			}
+		})
+	}
+}
+
+func TestReadyClosedDatabase(t *testing.T) {
+	r := &Resolver{}
+	e := r.Create(testOptions())
+	if e != nil {
+		t.Fatal(e)
+	}
+	close(r.stop)
+	r.wg.Wait()
+	r.BadgerDB.Close()
+
+	ready, byName := checks(r)
+	if ready || byName["database"].Healthy {
+		t.Errorf("ready with a closed database: %+v", byName["database"])
+	}
+}
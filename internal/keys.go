@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reservedKeys are the first path segments Curt routes use, or may use, so
+// they can never be claimed as keys, with root redirects the key would be
+// shadowed by the route
+var reservedKeys = []string{"c", "api", "admin", "status", "swagger", "metrics", "health", "favicon.ico", "robots.txt"}
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	ErrInvalidKey  = errors.New("invalid key: must be 1 to 64 letters, digits, - or _")
+	ErrReservedKey = errors.New("reserved key")
+	ErrKeyExists   = errors.New("key already exists")
+)
+
+type LinkOptions struct {
+	// BasePath prefixes every route, e.g. /short behind a proxy forwarding
+	// https://example.com/short/ to Curt
+	BasePath string
+	// RootRedirects serves the redirects at /<key> too, and uses it to build
+	// the Curt(s)
+	RootRedirects bool
+	// ReservedKeys are reserved on top of the built-in ones
+	ReservedKeys []string
+	// MaxChainDepth is how many Curt(s) a target URL may go through before
+	// the final URL, 0 rejects every target that is a Curt
+	MaxChainDepth int
+}
+
+func (o LinkOptions) validate() error {
+	if o.BasePath != "" && (!strings.HasPrefix(o.BasePath, "/") || strings.HasSuffix(o.BasePath, "/")) {
+		return fmt.Errorf("invalid base path: %s, must start with / and not end with it, e.g. /short", o.BasePath)
+	}
+	if o.MaxChainDepth < 0 {
+		return fmt.Errorf("invalid max chain depth: %d, must be 0 or greater", o.MaxChainDepth)
+	}
+	return nil
+}
+
+// path returns the path of the Curt(s) before the key
+func (o LinkOptions) path() string {
+	if o.RootRedirects {
+		return o.BasePath + "/"
+	}
+	return o.BasePath + "/c/"
+}
+
+// ValidateKey checks a custom key, the reserved keys are compared ignoring
+// the case
+func (r *Resolver) ValidateKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return ErrInvalidKey
+	}
+	if r.reservedKeys[strings.ToLower(key)] {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+func (r *Resolver) setReservedKeys(keys []string) {
+	r.reservedKeys = map[string]bool{}
+	for _, key := range append(reservedKeys, keys...) {
+		r.reservedKeys[strings.ToLower(key)] = true
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/rs/zerolog/log"
+)
+
+type GCOptions struct {
+	// Interval between scheduled value log GCs, 0 disables them
+	Interval     time.Duration
+	DiscardRatio float64
+	// Flatten compacts the whole LSM tree before every scheduled GC
+	Flatten bool
+}
+
+type GCResult struct {
+	Rewrites       int
+	ReclaimedBytes int64
+	Duration       time.Duration
+}
+
+// GCStats are the totals of every GC run since startup
+type GCStats struct {
+	Runs           uint64
+	Failures       uint64
+	Rewrites       uint64
+	ReclaimedBytes int64
+	LastRun        time.Time
+}
+
+type maintenance struct {
+	options GCOptions
+	// running serializes GC runs, badger rejects concurrent ones
+	running    sync.Mutex
+	statsMutex sync.RWMutex
+	stats      GCStats
+}
+
+var ErrGCRunning = errors.New("a value log GC is already running")
+
+func (o GCOptions) validate() error {
+	if o.Interval < 0 {
+		return fmt.Errorf("invalid GC interval: %s, must be 0 (disabled) or greater", o.Interval)
+	}
+	if o.DiscardRatio <= 0 || o.DiscardRatio >= 1 {
+		return fmt.Errorf("invalid GC discard ratio: %g, must be between 0 and 1, both excluded", o.DiscardRatio)
+	}
+	return nil
+}
+
+// vlogSize sums the value log files on disk, badger only refreshes DB.Size once a minute
+func vlogSize(dir string) (size int64) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	for _, file := range files {
+		info, e := os.Stat(file)
+		if e == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// RunGC rewrites value log files until badger finds nothing left to reclaim,
+// if flatten is set the LSM tree is compacted first
+func (r *Resolver) RunGC(flatten bool) (result GCResult, e error) {
+	if !r.maintenance.running.TryLock() {
+		return result, ErrGCRunning
+	}
+	defer r.maintenance.running.Unlock()
+
+	opts := r.BadgerDB.Opts()
+	if opts.InMemory {
+		return result, badger.ErrGCInMemoryMode
+	}
+
+	start := time.Now()
+	before := vlogSize(opts.ValueDir)
+
+	defer func() {
+		result.Duration = time.Since(start)
+		result.ReclaimedBytes = before - vlogSize(opts.ValueDir)
+		if result.ReclaimedBytes < 0 {
+			result.ReclaimedBytes = 0
+		}
+
+		r.maintenance.statsMutex.Lock()
+		defer r.maintenance.statsMutex.Unlock()
+		stats := &r.maintenance.stats
+		stats.Runs++
+		stats.Rewrites += uint64(result.Rewrites)
+		stats.ReclaimedBytes += result.ReclaimedBytes
+		stats.LastRun = start
+		if e != nil {
+			stats.Failures++
+			log.Error().Str("service", "badgerDB").Err(e).Msg("value log GC failed")
+			return
+		}
+
+		log.Info().Str("service", "badgerDB").Int("rewrites", result.Rewrites).Str("reclaimed", humanize.IBytes(uint64(result.ReclaimedBytes))).Str("duration", result.Duration.String()).Msg("value log GC completed")
+	}()
+
+	if flatten {
+		workers := opts.NumCompactors
+		if workers < 1 {
+			workers = 1
+		}
+		e = r.BadgerDB.Flatten(workers)
+		if e != nil {
+			return result, e
+		}
+	}
+
+	for {
+		e = r.BadgerDB.RunValueLogGC(r.maintenance.options.DiscardRatio)
+		switch e {
+		case nil:
+			result.Rewrites++
+		case badger.ErrNoRewrite:
+			return result, nil
+		default:
+			return result, e
+		}
+	}
+}
+
+// GCStats returns a snapshot of the GC totals
+func (r *Resolver) GCStats() GCStats {
+	r.maintenance.statsMutex.RLock()
+	defer r.maintenance.statsMutex.RUnlock()
+	return r.maintenance.stats
+}
+
+func (r *Resolver) gcScheduler() {
+	defer r.wg.Done()
+
+	o := r.maintenance.options
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	log.Info().Str("service", "badgerDB").Str("interval", o.Interval.String()).Float64("discard_ratio", o.DiscardRatio).Bool("flatten", o.Flatten).Msg("scheduled value log GC enabled")
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.RunGC(o.Flatten)
+		}
+	}
+}
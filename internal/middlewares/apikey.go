@@ -0,0 +1,334 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/salvatore-081/curt/internal/rbac"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// ActorKey is where the actor of the request, its principal qualified
+	// as the owner of its links, is stored in the gin context
+	ActorKey = "actor"
+	// Anonymous is the actor of the requests when the auth is disabled
+	Anonymous = "anonymous"
+	// DefaultAPIKeyName names the key set with X_API_KEY
+	DefaultAPIKeyName = "default"
+
+	sha256Prefix = "sha256:"
+)
+
+// argon2id parameters of HashAPIKey, the OWASP minimum, since the hash of
+// an unknown key is computed on every attempt with it
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// apiKeyNamePattern matches the names of the keys and of their workspaces
+var apiKeyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// APIKey authenticates the requests sending a key matching it as Name, in
+// Workspace, with Role
+type APIKey struct {
+	Name      string
+	Workspace string
+	Role      rbac.Role
+	// digest is the SHA-256 of a plain key or of a sha256: one
+	digest []byte
+	// argon2 verifies an argon2 hash
+	argon2 *argon2Hash
+}
+
+type argon2Hash struct {
+	variant string
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+// parseArgon2 parses a PHC string such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func parseArgon2(s string) (*argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || (parts[1] != "argon2id" && parts[1] != "argon2i") {
+		return nil, fmt.Errorf("must be $argon2id$v=19$m=...,t=...,p=...$salt$hash")
+	}
+
+	var version int
+	_, e := fmt.Sscanf(parts[2], "v=%d", &version)
+	if e != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	h := argon2Hash{variant: parts[1]}
+	_, e = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if e != nil || h.memory == 0 || h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2 parameters: %s", parts[3])
+	}
+
+	h.salt, e = base64.RawStdEncoding.DecodeString(parts[4])
+	if e != nil {
+		return nil, fmt.Errorf("invalid argon2 salt")
+	}
+	h.hash, e = base64.RawStdEncoding.DecodeString(parts[5])
+	if e != nil || len(h.hash) == 0 {
+		return nil, fmt.Errorf("invalid argon2 hash")
+	}
+	return &h, nil
+}
+
+func (h *argon2Hash) verify(key string) bool {
+	var hash []byte
+	if h.variant == "argon2i" {
+		hash = argon2.Key([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	} else {
+		hash = argon2.IDKey([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	}
+	return subtle.ConstantTimeCompare(hash, h.hash) == 1
+}
+
+// HashAPIKey returns the argon2id hash of key, in the PHC format accepted
+// in place of the key itself
+func HashAPIKey(key string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	_, e := rand.Read(salt)
+	if e != nil {
+		return "", e
+	}
+	hash := argon2.IDKey([]byte(key), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// newAPIKey parses key, given as is, as sha256:<hex> or as an argon2 hash
+func newAPIKey(name string, key string) (APIKey, error) {
+	if !apiKeyNamePattern.MatchString(name) {
+		return APIKey{}, fmt.Errorf("invalid API key name: %s, must be 1 to 64 letters, digits, dots, dashes or underscores", name)
+	}
+
+	switch {
+	case key == "":
+		return APIKey{}, fmt.Errorf("invalid API key %s: empty", name)
+	case strings.HasPrefix(key, sha256Prefix):
+		digest, e := hex.DecodeString(strings.TrimPrefix(key, sha256Prefix))
+		if e != nil || len(digest) != sha256.Size {
+			return APIKey{}, fmt.Errorf("invalid API key %s: the sha256: hash must be 64 hex digits", name)
+		}
+		return APIKey{Name: name, digest: digest}, nil
+	case strings.HasPrefix(key, "$argon2"):
+		h, e := parseArgon2(key)
+		if e != nil {
+			return APIKey{}, fmt.Errorf("invalid API key %s: %w", name, e)
+		}
+		return APIKey{Name: name, argon2: h}, nil
+	default:
+		digest := sha256.Sum256([]byte(key))
+		return APIKey{Name: name, digest: digest[:]}, nil
+	}
+}
+
+// APIKeys verifies the keys sent in the X-API-Key header
+type APIKeys struct {
+	keys []APIKey
+
+	mutex sync.RWMutex
+	// verified holds the names of the keys already verified, by their
+	// SHA-256, so that argon2 only runs the first time. It only ever holds
+	// valid keys, hence no more entries than keys
+	verified map[[sha256.Size]byte]string
+}
+
+// ReadAPIKeys reads name:key pairs from a file or secret mount, one per
+// line, skipping blanks and # comments
+func ReadAPIKeys(path string) ([]string, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	pairs := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pairs = append(pairs, line)
+	}
+	return pairs, scanner.Err()
+}
+
+// ParseAPIKeys parses name:key pairs, the key set with X_API_KEY, if any,
+// comes first as default. Each key is given as is, as sha256:<hex> or as
+// an argon2 hash, and belongs to the workspace following its name, as in
+// name@workspace:key, or to the default one
+func ParseAPIKeys(xAPIKey string, pairs []string) (*APIKeys, error) {
+	k := &APIKeys{verified: map[[sha256.Size]byte]string{}}
+	if xAPIKey != "" {
+		key, e := newAPIKey(DefaultAPIKeyName, xAPIKey)
+		if e != nil {
+			return nil, e
+		}
+		k.keys = append(k.keys, key)
+	}
+
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid API key: %s, must be name:key or name@workspace:key", name)
+		}
+		name, workspace, scoped := strings.Cut(name, "@")
+		if scoped && !apiKeyNamePattern.MatchString(workspace) {
+			return nil, fmt.Errorf("invalid workspace of API key %s: %s, must be 1 to 64 letters, digits, dots, dashes or underscores", name, workspace)
+		}
+		key, e := newAPIKey(name, value)
+		if e != nil {
+			return nil, e
+		}
+		key.Workspace = workspace
+		for _, other := range k.keys {
+			if other.Name == name {
+				return nil, fmt.Errorf("duplicate API key name: %s", name)
+			}
+		}
+		k.keys = append(k.keys, key)
+	}
+	return k, nil
+}
+
+// Enabled tells whether any key is configured, without keys the auth is
+// disabled
+func (k *APIKeys) Enabled() bool {
+	return k != nil && len(k.keys) > 0
+}
+
+// workspace returns the workspace of the key named name
+func (k *APIKeys) workspace(name string) string {
+	for _, apiKey := range k.keys {
+		if apiKey.Name == name {
+			return apiKey.Workspace
+		}
+	}
+	return ""
+}
+
+// roles returns the role of the key named name
+func (k *APIKeys) roles(name string) []rbac.Role {
+	for _, apiKey := range k.keys {
+		if apiKey.Name == name && apiKey.Role != "" {
+			return []rbac.Role{apiKey.Role}
+		}
+	}
+	return nil
+}
+
+// AssignRoles gives the keys their role from name:role pairs, the others
+// get defaultRole, but for the one set with X_API_KEY, which is an admin
+func (k *APIKeys) AssignRoles(defaultRole string, pairs []string) error {
+	role, ok := rbac.ParseRole(defaultRole)
+	if !ok {
+		return fmt.Errorf("invalid default API key role: %s, must be viewer, editor or admin", defaultRole)
+	}
+	for i := range k.keys {
+		k.keys[i].Role = role
+		if k.keys[i].Name == DefaultAPIKeyName {
+			k.keys[i].Role = rbac.Admin
+		}
+	}
+
+	assigned := map[string]bool{}
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, ":")
+		role, ok := rbac.ParseRole(value)
+		if !ok {
+			return fmt.Errorf("invalid role of API key %s: %s, must be viewer, editor or admin", name, value)
+		}
+		if assigned[name] {
+			return fmt.Errorf("duplicate role of API key %s", name)
+		}
+		assigned[name] = true
+
+		found := false
+		for i := range k.keys {
+			if k.keys[i].Name == name {
+				k.keys[i].Role = role
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("role given to unknown API key: %s", name)
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys, without their secret part
+func (k *APIKeys) Keys() []APIKey {
+	keys := make([]APIKey, len(k.keys))
+	for i, apiKey := range k.keys {
+		keys[i] = APIKey{Name: apiKey.Name, Workspace: apiKey.Workspace, Role: apiKey.Role}
+	}
+	return keys
+}
+
+// cached returns the name of key if it was already verified, or if it is
+// a plain or sha256: one, which are cheap to check
+func (k *APIKeys) cached(key string) (string, [sha256.Size]byte, bool) {
+	digest := sha256.Sum256([]byte(key))
+
+	k.mutex.RLock()
+	name, ok := k.verified[digest]
+	k.mutex.RUnlock()
+	if ok {
+		return name, digest, true
+	}
+
+	// every key is compared, so that the time doesn't tell which one matched
+	for _, apiKey := range k.keys {
+		if apiKey.digest != nil && subtle.ConstantTimeCompare(digest[:], apiKey.digest) == 1 {
+			name, ok = apiKey.Name, true
+		}
+	}
+	return name, digest, ok
+}
+
+// Lookup returns the name of key, running argon2 for the keys given as
+// hashes unless key was already verified
+func (k *APIKeys) Lookup(key string) (string, bool) {
+	if !k.Enabled() || key == "" {
+		return "", false
+	}
+
+	name, digest, ok := k.cached(key)
+	if ok {
+		return name, true
+	}
+
+	for _, apiKey := range k.keys {
+		if apiKey.argon2 != nil && apiKey.argon2.verify(key) {
+			k.mutex.Lock()
+			k.verified[digest] = apiKey.Name
+			k.mutex.Unlock()
+			return apiKey.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal/rbac"
+)
+
+func sha256Key(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return sha256Prefix + hex.EncodeToString(digest[:])
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		xAPIKey string
+		pairs   []string
+		err     string
+	}{
+		{"none", "", nil, ""},
+		{"default only", "secret", nil, ""},
+		{"pairs", "secret", []string{"ci:one", "ops@team:two", "hashed:" + sha256Key("three")}, ""},
+		{"missing key", "", []string{"ci"}, "must be name:key"},
+		{"empty key", "", []string{"ci:"}, "empty"},
+		{"invalid name", "", []string{"c i:one"}, "invalid API key name"},
+		{"name too long", "", []string{strings.Repeat("a", 65) + ":one"}, "invalid API key name"},
+		{"invalid workspace", "", []string{"ci@a/b:one"}, "invalid workspace"},
+		{"empty workspace", "", []string{"ci@:one"}, "invalid workspace"},
+		{"duplicate name", "", []string{"ci:one", "ci@team:two"}, "duplicate API key name"},
+		{"duplicate default", "secret", []string{"default:one"}, "duplicate API key name"},
+		{"short sha256", "", []string{"ci:sha256:abcd"}, "64 hex digits"},
+		{"sha256 not hex", "", []string{"ci:sha256:" + strings.Repeat("z", 64)}, "64 hex digits"},
+		{"invalid argon2", "", []string{"ci:$argon2id$v=19$m=0,t=2,p=1$c2FsdA$aGFzaA"}, "invalid argon2 parameters"},
+		{"argon2 version", "", []string{"ci:$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$aGFzaA"}, "unsupported argon2 version"},
+		{"argon2d", "", []string{"ci:$argon2d$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA"}, "must be $argon2id"},
+	}
+	for _, tt := range tests {
+		_, e := ParseAPIKeys(tt.xAPIKey, tt.pairs)
+		if tt.err == "" && e != nil {
+			t.Errorf("%s: %v", tt.name, e)
+		}
+		if tt.err != "" && (e == nil || !strings.Contains(e.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, e, tt.err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	hash, e := HashAPIKey("argon-secret")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+	k, e := ParseAPIKeys("root-secret", []string{
+		"plain:plain-secret",
+		"digest:" + sha256Key("digest-secret"),
+		"argon:" + hash,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		key  string
+		name string
+	}{
+		{"root-secret", DefaultAPIKeyName},
+		{"plain-secret", "plain"},
+		{"digest-secret", "digest"},
+		{"argon-secret", "argon"},
+		// verified once, then cached
+		{"argon-secret", "argon"},
+		{"", ""},
+		{"wrong", ""},
+		{"plain-secret ", ""},
+		{sha256Key("digest-secret"), ""},
+		{hash, ""},
+	}
+	for _, tt := range tests {
+		name, ok := k.Lookup(tt.key)
+		if ok != (tt.name != "") || name != tt.name {
+			t.Errorf("Lookup(%q) = %q, %t, want %q", tt.key, name, ok, tt.name)
+		}
+	}
+	if len(k.verified) != 1 {
+		t.Errorf("got %d verified keys cached, want only the argon2 one", len(k.verified))
+	}
+
+	var disabled *APIKeys
+	if name, ok := disabled.Lookup("root-secret"); ok {
+		t.Errorf("Lookup without keys = %q", name)
+	}
+}
+
+func TestReadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	e := os.WriteFile(path, []byte("# CI\nci:one\n\n  ops@team:two  \n"), 0o600)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pairs, e := ReadAPIKeys(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(pairs) != 2 || pairs[0] != "ci:one" || pairs[1] != "ops@team:two" {
+		t.Errorf("got %q", pairs)
+	}
+
+	_, e = ReadAPIKeys(filepath.Join(t.TempDir(), "missing"))
+	if e == nil {
+		t.Error("no error reading a missing file")
+	}
+}
+
+func TestAssignRoles(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultRole string
+		pairs       []string
+		roles       map[string]rbac.Role
+		err         string
+	}{
+		{"default role", "viewer", nil, map[string]rbac.Role{"default": rbac.Admin, "ci": rbac.Viewer, "ops": rbac.Viewer}, ""},
+		{"given roles", "viewer", []string{"ci:editor", "ops:admin"}, map[string]rbac.Role{"default": rbac.Admin, "ci": rbac.Editor, "ops": rbac.Admin}, ""},
+		{"default key demoted", "editor", []string{"default:viewer"}, map[string]rbac.Role{"default": rbac.Viewer, "ci": rbac.Editor, "ops": rbac.Editor}, ""},
+		{"invalid default role", "owner", nil, nil, "invalid default API key role"},
+		{"invalid role", "viewer", []string{"ci:owner"}, nil, "invalid role of API key ci"},
+		{"missing role", "viewer", []string{"ci"}, nil, "invalid role of API key ci"},
+		{"duplicate role", "viewer", []string{"ci:editor", "ci:admin"}, nil, "duplicate role of API key ci"},
+		{"unknown key", "viewer", []string{"nobody:admin"}, nil, "unknown API key: nobody"},
+	}
+	for _, tt := range tests {
+		k, e := ParseAPIKeys("root-secret", []string{"ci:one", "ops@team:two"})
+		if e != nil {
+			t.Fatal(e)
+		}
+		e = k.AssignRoles(tt.defaultRole, tt.pairs)
+		if tt.err != "" {
+			if e == nil || !strings.Contains(e.Error(), tt.err) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, e, tt.err)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("%s: %v", tt.name, e)
+			continue
+		}
+		for _, key := range k.Keys() {
+			if key.Role != tt.roles[key.Name] {
+				t.Errorf("%s: %s got role %s, want %s", tt.name, key.Name, key.Role, tt.roles[key.Name])
+			}
+		}
+	}
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	k, e := ParseAPIKeys("", []string{"ci:one", "ops@team:two"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = k.AssignRoles("editor", []string{"ops:viewer"})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		key       string
+		principal Principal
+		err       error
+	}{
+		{"one", Principal{Subject: "ci", Method: AuthMethodAPIKey, Roles: []rbac.Role{rbac.Editor}}, nil},
+		{"two", Principal{Subject: "ops", Method: AuthMethodAPIKey, Workspace: "team", Roles: []rbac.Role{rbac.Viewer}}, nil},
+		{"", Principal{}, ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("X-API-Key", tt.key)
+
+		p, e := k.Authenticate(c)
+		if e != tt.err {
+			t.Errorf("%q: got error %v, want %v", tt.key, e, tt.err)
+		}
+		if p.Subject != tt.principal.Subject || p.Method != tt.principal.Method || p.Workspace != tt.principal.Workspace ||
+			len(p.Roles) != len(tt.principal.Roles) || (len(p.Roles) > 0 && p.Roles[0] != tt.principal.Roles[0]) {
+			t.Errorf("%q: got %+v, want %+v", tt.key, p, tt.principal)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("X-API-Key", "wrong")
+	_, e = k.Authenticate(c)
+	if authErr, ok := e.(*AuthError); !ok || authErr.Reason != "wrong_key" {
+		t.Errorf("wrong key: got %v, want a wrong_key AuthError", e)
+	}
+}
@@ -0,0 +1,190 @@
+package middlewares
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal/rbac"
+)
+
+const (
+	// PrincipalKey is where the Principal of the request is stored in the
+	// gin context
+	PrincipalKey = "principal"
+
+	AuthMethodAPIKey = "api_key"
+	AuthMethodJWT    = "jwt"
+
+	// OwnerAPIKeyPrefix and OwnerJWTPrefix qualify the owners of the links
+	// with the auth method, so that an API key and a token subject of the
+	// same name don't own the same links
+	OwnerAPIKeyPrefix = "key:"
+	OwnerJWTPrefix    = "jwt:"
+)
+
+// ErrNoCredentials is returned by the providers when the request carries
+// none of their credentials, so that the next one is tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// AuthError rejects a request carrying wrong credentials, Reason labels
+// the auth failures metric
+type AuthError struct {
+	Reason  string
+	Message string
+	// Challenge replaces the WWW-Authenticate header of the provider, to
+	// tell the client what was wrong
+	Challenge string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Principal is who a request is authenticated as
+type Principal struct {
+	// Subject is the name of the API key or the subject of the token,
+	// qualified by Method it is the actor of the audit log and the owner of
+	// the links created
+	Subject string
+	Method  string
+	// Workspace holds the links the principal creates and sees, empty for
+	// the default one
+	Workspace string
+	Roles     []rbac.Role
+	Scopes    []string
+}
+
+// Owner returns the principal as the owner of the links it creates, its
+// subject qualified by its auth method, e.g. key:ci or jwt:alice
+func (p Principal) Owner() string {
+	if p.Method == AuthMethodJWT {
+		return OwnerJWTPrefix + p.Subject
+	}
+	return OwnerAPIKeyPrefix + p.Subject
+}
+
+// PolicySubject returns the principal as the subject of the policy, named
+// as the owner of its links
+func (p Principal) PolicySubject() rbac.Subject {
+	return rbac.Subject{Name: p.Owner(), Roles: p.Roles}
+}
+
+// AuthProvider authenticates the requests carrying its credentials
+type AuthProvider interface {
+	Enabled() bool
+	// Authenticate returns ErrNoCredentials when the request carries none
+	// of the credentials of the provider, an *AuthError when they are wrong
+	Authenticate(c *gin.Context) (Principal, error)
+	// Challenge is the WWW-Authenticate header asking for the credentials
+	Challenge() string
+}
+
+// AuthProviders are tried in order, the first one finding its credentials
+// in the request decides
+type AuthProviders []AuthProvider
+
+// Enabled tells whether any provider is, without providers the auth is
+// disabled
+func (p AuthProviders) Enabled() bool {
+	for _, provider := range p {
+		if provider.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeys returns the API keys provider, nil if there is none
+func (p AuthProviders) APIKeys() *APIKeys {
+	for _, provider := range p {
+		if keys, ok := provider.(*APIKeys); ok {
+			return keys
+		}
+	}
+	return nil
+}
+
+// enabled returns the providers that are
+func (p AuthProviders) enabled() AuthProviders {
+	enabled := AuthProviders{}
+	for _, provider := range p {
+		if provider.Enabled() {
+			enabled = append(enabled, provider)
+		}
+	}
+	return enabled
+}
+
+// Authenticate verifies the X-API-Key header
+func (k *APIKeys) Authenticate(c *gin.Context) (Principal, error) {
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	name, ok := k.Lookup(key)
+	if !ok {
+		return Principal{}, &AuthError{Reason: "wrong_key", Message: "wrong X-API-Key"}
+	}
+	return Principal{Subject: name, Method: AuthMethodAPIKey, Workspace: k.workspace(name), Roles: k.roles(name)}, nil
+}
+
+func (k *APIKeys) Challenge() string {
+	return `APIKey realm="curt", header="X-API-Key"`
+}
+
+// bearerToken returns the token of the Authorization header, empty if it
+// has none
+func bearerToken(c *gin.Context) string {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Actor returns the actor of the request, key:<name> or jwt:<subject>, or
+// Anonymous with the auth disabled
+func Actor(c *gin.Context) string {
+	return c.GetString(ActorKey)
+}
+
+// GetPrincipal returns the principal of the request, false before the auth
+// or when it is disabled
+func GetPrincipal(c *gin.Context) (Principal, bool) {
+	v, ok := c.Get(PrincipalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	p, ok := v.(Principal)
+	return p, ok
+}
+
+// Owner returns the owner of the links created by the request, empty when
+// the auth is disabled
+func Owner(c *gin.Context) string {
+	p, ok := GetPrincipal(c)
+	if !ok {
+		return ""
+	}
+	return p.Owner()
+}
+
+// Authorize evaluates the policy for the principal of the request, every
+// permission is granted with the auth disabled
+func Authorize(c *gin.Context, permission rbac.Permission, resource *rbac.Resource) rbac.Decision {
+	p, ok := GetPrincipal(c)
+	if !ok {
+		return rbac.Decision{Allowed: true}
+	}
+	return rbac.Evaluate(p.PolicySubject(), permission, resource)
+}
+
+// Workspace returns the workspace of the request, empty for the default
+// one, and whether it reaches every workspace, as the principals allowed to
+// manage them do
+func Workspace(c *gin.Context) (string, bool) {
+	p, _ := GetPrincipal(c)
+	return p.Workspace, Authorize(c, rbac.ManageWorkspaces, nil).Allowed
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"testing"
+
+	"github.com/salvatore-081/curt/internal/rbac"
+)
+
+func TestPrincipalOwner(t *testing.T) {
+	key := Principal{Subject: "alice", Method: AuthMethodAPIKey, Roles: []rbac.Role{rbac.Editor}}
+	token := Principal{Subject: "alice", Method: AuthMethodJWT, Roles: []rbac.Role{rbac.Editor}}
+	if key.Owner() != "key:alice" || token.Owner() != "jwt:alice" {
+		t.Fatalf("got owners %q and %q, want key:alice and jwt:alice", key.Owner(), token.Owner())
+	}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		owner     string
+		allowed   bool
+	}{
+		{"key on its links", key, key.Owner(), true},
+		{"token on its links", token, token.Owner(), true},
+		{"token on the links of the key of the same name", token, key.Owner(), false},
+		{"key on the links of the token of the same name", key, token.Owner(), false},
+		{"bare owner", token, "alice", false},
+	}
+	for _, tt := range tests {
+		d := rbac.Evaluate(tt.principal.PolicySubject(), rbac.DeleteLinks, &rbac.Resource{Owner: tt.owner})
+		if d.Allowed != tt.allowed {
+			t.Errorf("%s: got %+v, want allowed %t", tt.name, d, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+type CORSOptions struct {
+	Enabled bool
+	// AllowOrigins holds exact origins such as https://app.example.com,
+	// wildcard subdomains such as https://*.example.com, or * for any origin
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// originPattern matches an origin exactly or, when wildcard is set, any
+// subdomain of host with the same scheme and port
+type originPattern struct {
+	scheme   string
+	host     string
+	wildcard bool
+}
+
+func parseOrigin(origin string) (originPattern, error) {
+	u, e := url.Parse(origin)
+	if e != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return originPattern{}, fmt.Errorf("invalid CORS origin: %s, must be scheme://host[:port]", origin)
+	}
+
+	p := originPattern{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Host)}
+	if strings.HasPrefix(p.host, "*.") {
+		p.wildcard = true
+		p.host = p.host[1:]
+	}
+	if strings.Contains(p.host, "*") {
+		return originPattern{}, fmt.Errorf("invalid CORS origin: %s, a wildcard is only allowed as the first label, e.g. https://*.example.com", origin)
+	}
+	return p, nil
+}
+
+func (p originPattern) match(o originPattern) bool {
+	if p.scheme != o.scheme {
+		return false
+	}
+	if !p.wildcard {
+		return p.host == o.host
+	}
+	return strings.HasSuffix(o.host, p.host) && len(o.host) > len(p.host)
+}
+
+// GinCORSMiddleware returns nil when CORS is disabled, browsers then reject
+// every cross-origin request
+func GinCORSMiddleware(o CORSOptions) (gin.HandlerFunc, error) {
+	if !o.Enabled {
+		return nil, nil
+	}
+	if len(o.AllowOrigins) == 0 {
+		return nil, fmt.Errorf("missing CORS allowed origins, set at least one or disable CORS")
+	}
+	if o.MaxAge < 0 {
+		return nil, fmt.Errorf("invalid CORS max age: %s, must be 0 or greater", o.MaxAge)
+	}
+
+	config := cors.Config{
+		AllowMethods:     o.AllowMethods,
+		AllowHeaders:     o.AllowHeaders,
+		ExposeHeaders:    o.ExposeHeaders,
+		AllowCredentials: o.AllowCredentials,
+		MaxAge:           o.MaxAge,
+	}
+
+	var patterns []originPattern
+	for _, origin := range o.AllowOrigins {
+		if origin == "*" {
+			config.AllowAllOrigins = true
+			continue
+		}
+		p, e := parseOrigin(origin)
+		if e != nil {
+			return nil, e
+		}
+		patterns = append(patterns, p)
+	}
+
+	if config.AllowAllOrigins {
+		if len(patterns) > 0 {
+			return nil, fmt.Errorf("invalid CORS origins: * already allows every origin, remove the others")
+		}
+		// browsers refuse credentials from a wildcard origin
+		if o.AllowCredentials {
+			return nil, fmt.Errorf("invalid CORS config: credentials can not be allowed for every origin, list the origins instead of *")
+		}
+	} else {
+		config.AllowOriginFunc = func(origin string) bool {
+			o, e := parseOrigin(origin)
+			if e != nil || o.wildcard {
+				return false
+			}
+			for _, p := range patterns {
+				if p.match(o) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	e := config.Validate()
+	if e != nil {
+		return nil, e
+	}
+	return cors.New(config), nil
+}
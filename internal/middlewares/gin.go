@@ -1,18 +1,61 @@
 package middlewares
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal/metrics"
+	"github.com/salvatore-081/curt/internal/rbac"
 	"github.com/salvatore-081/curt/pkg/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is where the request ID is stored in the gin context
+	RequestIDKey = "requestID"
+
+	maxRequestIDLength = 128
+)
+
+// validRequestID accepts printable ASCII only, so a propagated ID can't forge log lines
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GinLoggerMiddleware logs every request, tagging it with the X-Request-ID
+// received from upstream or with a new one, which is echoed in the response
 func GinLoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		now := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
 		c.Next()
 		t := time.Since(now).String()
 
@@ -38,33 +81,101 @@ func GinLoggerMiddleware() gin.HandlerFunc {
 			event = event.Err(e.Err)
 		}
 
-		event.Str("service", "API").Str("method", c.Request.Method).Str("path", path).Int("status", statusCode).Str("client ip", c.ClientIP()).Str("response time", t).Msg("")
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.HasTraceID() {
+			event = event.Str("trace_id", span.TraceID().String())
+		}
+
+		event.Str("service", "API").Str("request_id", requestID).Str("method", c.Request.Method).Str("path", path).Int("status", statusCode).Str("client_ip", c.ClientIP()).Str("response_time", t).Msg("")
 	}
 }
 
-func GinAuthMiddleware(xAPIKey string) gin.HandlerFunc {
+// GinAuthMiddleware authenticates the requests with the first of providers
+// finding its credentials, recording the principal and, as the actor of the
+// request, its owner. Without providers the auth is disabled
+func GinAuthMiddleware(providers AuthProviders) gin.HandlerFunc {
+	providers = providers.enabled()
+
 	return func(c *gin.Context) {
-		var header models.Header
+		if len(providers) == 0 {
+			c.Set(ActorKey, Anonymous)
+			return
+		}
 
-		if len(xAPIKey) > 0 {
-			e := c.ShouldBindHeader(&header)
+		for _, provider := range providers {
+			p, e := provider.Authenticate(c)
+			if e == ErrNoCredentials {
+				continue
+			}
 			if e != nil {
-				c.JSON(http.StatusBadRequest,
-					models.GenericError{
-						Message: e.Error(),
-					})
-				c.Abort()
+				authErr, ok := e.(*AuthError)
+				if !ok {
+					authErr = &AuthError{Reason: "error", Message: e.Error()}
+				}
+				challenge := authErr.Challenge
+				if challenge == "" {
+					challenge = provider.Challenge()
+				}
+				unauthorized(c, authErr.Reason, authErr.Message, challenge)
 				return
 			}
 
-			if header.XApiKey != xAPIKey {
-				c.JSON(http.StatusUnauthorized,
-					models.GenericError{
-						Message: "wrong X-API-Key",
-					})
-				c.Abort()
-				return
-			}
+			c.Set(PrincipalKey, p)
+			c.Set(ActorKey, p.Owner())
+			return
+		}
+
+		challenges := make([]string, len(providers))
+		for i, provider := range providers {
+			challenges[i] = provider.Challenge()
+		}
+		unauthorized(c, "missing_credentials", missingCredentials(providers), challenges...)
+	}
+}
+
+// missingCredentials tells which credentials the request should carry
+func missingCredentials(providers AuthProviders) string {
+	credentials := []string{}
+	for _, provider := range providers {
+		switch provider.(type) {
+		case *APIKeys:
+			credentials = append(credentials, "X-API-Key")
+		case *JWTProvider:
+			credentials = append(credentials, "bearer token")
+		}
+	}
+	return "missing " + strings.Join(credentials, " or ")
+}
+
+func unauthorized(c *gin.Context, reason string, message string, challenges ...string) {
+	metrics.AuthFailures.WithLabelValues(reason).Inc()
+	for _, challenge := range challenges {
+		c.Writer.Header().Add("WWW-Authenticate", challenge)
+	}
+	c.JSON(http.StatusUnauthorized,
+		models.GenericError{
+			Message: message,
+		})
+	c.Abort()
+}
+
+// GinPermissionMiddleware requires permission, on any resource, the
+// handlers check the ownership of the ones they reach
+func GinPermissionMiddleware(permission rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := Authorize(c, permission, nil)
+		if !d.Allowed {
+			Forbidden(c, d)
 		}
 	}
 }
+
+// Forbidden rejects a request denied by the policy, explaining why
+func Forbidden(c *gin.Context, d rbac.Decision) {
+	metrics.AuthFailures.WithLabelValues("forbidden").Inc()
+	c.JSON(http.StatusForbidden,
+		models.GenericError{
+			Message: "forbidden",
+			Details: d.Reason,
+		})
+	c.Abort()
+}
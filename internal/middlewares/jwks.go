@@ -0,0 +1,224 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxJWKSSize caps the JWKS and discovery documents read from the network
+const maxJWKSSize = 1 << 20
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// publicKey is a verification key, a key without Kid or Alg matches any
+type publicKey struct {
+	Kid string
+	Alg string
+	Key crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, e := decodeBase64URL(k.N)
+		if e != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus")
+		}
+		exponent, e := decodeBase64URL(k.E)
+		if e != nil || len(exponent) == 0 || len(exponent) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, e := decodeBase64URL(k.X)
+		if e != nil {
+			return nil, fmt.Errorf("invalid EC x")
+		}
+		y, e := decodeBase64URL(k.Y)
+		if e != nil {
+			return nil, fmt.Errorf("invalid EC y")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC point not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, e := decodeBase64URL(k.X)
+		if e != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+// parseJWKS parses a JWKS, skipping the encryption keys. Keys of unknown
+// types are skipped too, so that a new key type at the issuer doesn't break
+// the others
+func parseJWKS(b []byte) ([]publicKey, error) {
+	var set jwks
+	e := json.Unmarshal(b, &set)
+	if e != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", e)
+	}
+
+	keys := []publicKey{}
+	var skipped []string
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, e := k.publicKey()
+		if e != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", k.Kid, e))
+			continue
+		}
+		keys = append(keys, publicKey{Kid: k.Kid, Alg: k.Alg, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing key in the JWKS %s", strings.Join(skipped, ", "))
+	}
+	return keys, nil
+}
+
+// parsePEMKeys parses PEM public keys and certificates, such as the public
+// half of a locally generated key pair
+func parsePEMKeys(b []byte) ([]publicKey, error) {
+	keys := []publicKey{}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, e := x509.ParsePKIXPublicKey(block.Bytes)
+			if e != nil {
+				return nil, e
+			}
+			keys = append(keys, publicKey{Key: key})
+		case "CERTIFICATE":
+			cert, e := x509.ParseCertificate(block.Bytes)
+			if e != nil {
+				return nil, e
+			}
+			keys = append(keys, publicKey{Key: cert.PublicKey})
+		default:
+			return nil, fmt.Errorf("unsupported PEM block: %s, must be PUBLIC KEY or CERTIFICATE", block.Type)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	return keys, nil
+}
+
+// readKeys reads a JWKS or PEM public keys from a file
+func readKeys(path string) ([]publicKey, error) {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN")) {
+		return parsePEMKeys(b)
+	}
+	return parseJWKS(b)
+}
+
+func fetch(url string) ([]byte, error) {
+	res, e := jwksClient.Get(url)
+	if e != nil {
+		return nil, e
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxJWKSSize))
+}
+
+// fetchKeys fetches a JWKS
+func fetchKeys(url string) ([]publicKey, error) {
+	b, e := fetch(url)
+	if e != nil {
+		return nil, e
+	}
+	return parseJWKS(b)
+}
+
+// discoverJWKS returns the jwks_uri of the OpenID Connect provider issuer
+func discoverJWKS(issuer string) (string, error) {
+	b, e := fetch(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if e != nil {
+		return "", fmt.Errorf("unable to discover the JWKS of %s: %w", issuer, e)
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	e = json.Unmarshal(b, &discovery)
+	if e != nil {
+		return "", fmt.Errorf("invalid OpenID configuration of %s: %w", issuer, e)
+	}
+	if discovery.Issuer != issuer {
+		return "", fmt.Errorf("the OpenID configuration of %s is for issuer %s", issuer, discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("the OpenID configuration of %s has no jwks_uri", issuer)
+	}
+	return discovery.JWKSURI, nil
+}
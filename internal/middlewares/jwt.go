@@ -0,0 +1,432 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal/rbac"
+)
+
+// minJWKSRefresh is how often a token signed by an unknown key may trigger
+// a fetch of the JWKS, so that such tokens can't hammer the issuer
+const minJWKSRefresh = time.Minute
+
+// asymmetricAlgorithms are the algorithms a JWKS can verify, HMAC would
+// need a shared secret
+var asymmetricAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+type JWTOptions struct {
+	// Issuer is the iss the tokens must carry, without JWKSURL and JWKSFile
+	// its OpenID configuration gives the JWKS URL
+	Issuer string
+	// Audience must be in the aud of the tokens
+	Audience string
+	JWKSURL  string
+	// JWKSFile holds a JWKS or PEM public keys
+	JWKSFile string
+	// RefreshInterval is how often the keys are fetched or read again, 0
+	// disables it
+	RefreshInterval time.Duration
+	Algorithms      []string
+	// Leeway is the clock skew allowed checking exp, nbf and iat
+	Leeway time.Duration
+	// SubjectClaim names the principal, RolesClaim and ScopesClaim give its
+	// roles and scopes, as lists or space separated strings. Nested claims
+	// are reached with dots, e.g. realm_access.roles
+	SubjectClaim string
+	RolesClaim   string
+	ScopesClaim  string
+	// WorkspaceClaim gives the workspace of the principal, the default one
+	// when missing
+	WorkspaceClaim string
+	// RequiredScopes must all be granted to the tokens
+	RequiredScopes []string
+	// RoleMap maps the values of the roles claim to roles, as value:role
+	// pairs
+	RoleMap []string
+	// RoleNames lets the values naming a role grant it without RoleMap, off
+	// by default since any group of the issuer named admin would be one
+	RoleNames bool
+	// DefaultRole is given to the tokens without a role, empty for none
+	DefaultRole string
+}
+
+// roleMap parses the value:role pairs
+func (o JWTOptions) roleMap() (map[string]rbac.Role, error) {
+	roles := map[string]rbac.Role{}
+	for _, pair := range o.RoleMap {
+		i := strings.LastIndex(pair, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid JWT role mapping: %s, must be value:role", pair)
+		}
+		role, ok := rbac.ParseRole(pair[i+1:])
+		if !ok {
+			return nil, fmt.Errorf("invalid JWT role mapping: %s, the role must be viewer, editor or admin", pair)
+		}
+		roles[pair[:i]] = role
+	}
+	return roles, nil
+}
+
+func (o JWTOptions) enabled() bool {
+	return o.Issuer != "" || o.JWKSURL != "" || o.JWKSFile != ""
+}
+
+func (o JWTOptions) validate() error {
+	if o.JWKSURL != "" && o.JWKSFile != "" {
+		return fmt.Errorf("the JWKS URL and the JWKS file are mutually exclusive")
+	}
+	if len(o.Algorithms) == 0 {
+		return fmt.Errorf("no JWT algorithm allowed")
+	}
+	for _, alg := range o.Algorithms {
+		if !asymmetricAlgorithms[alg] {
+			return fmt.Errorf("unsupported JWT algorithm: %s, must be RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA", alg)
+		}
+	}
+	if o.SubjectClaim == "" {
+		return fmt.Errorf("the JWT subject claim is required")
+	}
+	if o.RefreshInterval < 0 || o.Leeway < 0 {
+		return fmt.Errorf("the JWKS refresh interval and the JWT leeway must not be negative")
+	}
+	if _, ok := rbac.ParseRole(o.DefaultRole); o.DefaultRole != "" && !ok {
+		return fmt.Errorf("invalid default JWT role: %s, must be viewer, editor or admin", o.DefaultRole)
+	}
+	return nil
+}
+
+// JWTProvider authenticates the requests with an Authorization: Bearer JWT
+// signed by one of the keys of the issuer
+type JWTProvider struct {
+	options JWTOptions
+	parser  *jwt.Parser
+	roleMap map[string]rbac.Role
+	// url is the JWKS URL, given or discovered, empty for a file
+	url string
+
+	mutex   sync.RWMutex
+	keys    []publicKey
+	fetched time.Time
+	// refresh serializes the loads, so that concurrent tokens signed by an
+	// unknown key trigger a single one
+	refresh sync.Mutex
+
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// NewJWTProvider loads the keys of the issuer, failing if they can't be.
+// A nil provider is returned when o configures none
+func NewJWTProvider(o JWTOptions) (*JWTProvider, error) {
+	if !o.enabled() {
+		return nil, nil
+	}
+	e := o.validate()
+	if e != nil {
+		return nil, e
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(o.Algorithms),
+		jwt.WithLeeway(o.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if o.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(o.Issuer))
+	}
+	if o.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(o.Audience))
+	}
+
+	roleMap, e := o.roleMap()
+	if e != nil {
+		return nil, e
+	}
+
+	p := &JWTProvider{
+		options: o,
+		parser:  jwt.NewParser(parserOptions...),
+		roleMap: roleMap,
+		url:     o.JWKSURL,
+		stop:    make(chan struct{}),
+	}
+	if p.url == "" && o.JWKSFile == "" {
+		p.url, e = discoverJWKS(o.Issuer)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	e = p.load()
+	if e != nil {
+		return nil, e
+	}
+
+	if o.RefreshInterval > 0 {
+		p.wg.Add(1)
+		go p.refreshLoop()
+	}
+	return p, nil
+}
+
+func (p *JWTProvider) source() string {
+	if p.url != "" {
+		return p.url
+	}
+	return p.options.JWKSFile
+}
+
+// load fetches or reads the keys, keeping the current ones on failure
+func (p *JWTProvider) load() error {
+	var keys []publicKey
+	var e error
+	if p.url != "" {
+		keys, e = fetchKeys(p.url)
+	} else {
+		keys, e = readKeys(p.options.JWKSFile)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.fetched = time.Now()
+	if e != nil {
+		return fmt.Errorf("unable to load the JWT keys from %s: %w", p.source(), e)
+	}
+	p.keys = keys
+	return nil
+}
+
+func (p *JWTProvider) refreshLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.refresh.Lock()
+			e := p.load()
+			p.refresh.Unlock()
+			if e != nil {
+				log.Warn().Str("service", "auth").Err(e).Msg("keeping the previous JWT keys")
+			}
+		}
+	}
+}
+
+// Close stops the refresh of the keys
+func (p *JWTProvider) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.once.Do(func() { close(p.stop) })
+	p.wg.Wait()
+	return nil
+}
+
+// candidates returns the keys that may have signed a token with kid and
+// alg, the keys without kid or alg match any
+func (p *JWTProvider) candidates(kid string, alg string) ([]jwt.VerificationKey, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	keys := []jwt.VerificationKey{}
+	found := false
+	for _, k := range p.keys {
+		if (k.Kid != "" && kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		keys = append(keys, k.Key)
+		found = found || (kid != "" && k.Kid == kid)
+	}
+	return keys, found || kid == ""
+}
+
+// keyfunc returns the keys matching the token, fetching the JWKS again when
+// its kid is unknown, as after a rotation at the issuer
+func (p *JWTProvider) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+
+	keys, found := p.candidates(kid, alg)
+	if !found && p.url != "" {
+		p.refresh.Lock()
+		p.mutex.RLock()
+		stale := time.Since(p.fetched) >= minJWKSRefresh
+		p.mutex.RUnlock()
+		if stale {
+			e := p.load()
+			if e != nil {
+				log.Warn().Str("service", "auth").Err(e).Msg("keeping the previous JWT keys")
+			}
+		}
+		p.refresh.Unlock()
+		keys, _ = p.candidates(kid, alg)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return jwt.VerificationKeySet{Keys: keys}, nil
+}
+
+func (p *JWTProvider) Enabled() bool {
+	return p != nil
+}
+
+func (p *JWTProvider) Challenge() string {
+	return `Bearer realm="curt"`
+}
+
+// invalidToken rejects a token, the message is safe to send as it only
+// tells which check failed
+func invalidToken(message string) *AuthError {
+	return &AuthError{
+		Reason:    "invalid_token",
+		Message:   "invalid bearer token: " + message,
+		Challenge: fmt.Sprintf(`Bearer realm="curt", error="invalid_token", error_description=%q`, message),
+	}
+}
+
+// Authenticate verifies the bearer token of the Authorization header,
+// mapping its claims to the principal
+func (p *JWTProvider) Authenticate(c *gin.Context) (Principal, error) {
+	raw := bearerToken(c)
+	if raw == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, e := p.parser.ParseWithClaims(raw, claims, p.keyfunc)
+	if e != nil {
+		return Principal{}, invalidToken(tokenError(e))
+	}
+
+	subject, _ := claim(claims, p.options.SubjectClaim).(string)
+	if subject == "" {
+		return Principal{}, invalidToken("missing " + p.options.SubjectClaim)
+	}
+
+	workspace, _ := claim(claims, p.options.WorkspaceClaim).(string)
+	if workspace != "" && !apiKeyNamePattern.MatchString(workspace) {
+		return Principal{}, invalidToken("invalid " + p.options.WorkspaceClaim)
+	}
+
+	principal := Principal{
+		Subject:   subject,
+		Method:    AuthMethodJWT,
+		Workspace: workspace,
+		Roles:     p.roles(claimValues(claims, p.options.RolesClaim)),
+		Scopes:    claimValues(claims, p.options.ScopesClaim),
+	}
+	for _, scope := range p.options.RequiredScopes {
+		if !contains(principal.Scopes, scope) {
+			return Principal{}, &AuthError{
+				Reason:    "insufficient_scope",
+				Message:   "the bearer token lacks the scope " + scope,
+				Challenge: fmt.Sprintf(`Bearer realm="curt", error="insufficient_scope", scope=%q`, strings.Join(p.options.RequiredScopes, " ")),
+			}
+		}
+	}
+	return principal, nil
+}
+
+// roles maps the values of the roles claim to roles, ignoring the unknown
+// ones, a token left without a role gets the default one
+func (p *JWTProvider) roles(values []string) []rbac.Role {
+	roles := []rbac.Role{}
+	for _, value := range values {
+		role, ok := p.roleMap[value]
+		if !ok && p.options.RoleNames {
+			role, ok = rbac.ParseRole(value)
+		}
+		if ok {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 && p.options.DefaultRole != "" {
+		roles = append(roles, rbac.Role(p.options.DefaultRole))
+	}
+	return roles
+}
+
+// tokenError describes why a token was rejected without echoing it
+func tokenError(e error) string {
+	switch {
+	case errors.Is(e, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(e, jwt.ErrTokenSignatureInvalid), errors.Is(e, jwt.ErrTokenUnverifiable):
+		return "unverifiable signature"
+	case errors.Is(e, jwt.ErrTokenExpired):
+		return "expired"
+	case errors.Is(e, jwt.ErrTokenNotValidYet), errors.Is(e, jwt.ErrTokenUsedBeforeIssued):
+		return "not valid yet"
+	case errors.Is(e, jwt.ErrTokenInvalidIssuer):
+		return "wrong issuer"
+	case errors.Is(e, jwt.ErrTokenInvalidAudience):
+		return "wrong audience"
+	case errors.Is(e, jwt.ErrTokenRequiredClaimMissing):
+		return "missing exp"
+	default:
+		return "invalid claims"
+	}
+}
+
+// claim returns the claim at path, walking the nested objects on dots
+func claim(claims jwt.MapClaims, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	var v interface{} = map[string]interface{}(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = object[name]
+	}
+	return v
+}
+
+// claimValues returns the strings of the claim at path, given as a list or
+// space separated as in the OAuth scope claim
+func claimValues(claims jwt.MapClaims, path string) []string {
+	switch v := claim(claims, path).(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
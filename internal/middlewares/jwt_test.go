@@ -0,0 +1,335 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/salvatore-081/curt/internal/rbac"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "curt"
+)
+
+// testJWKS serves the public keys it holds, counting the fetches
+type testJWKS struct {
+	mutex   sync.Mutex
+	keys    []jwk
+	fetches int
+}
+
+func (s *testJWKS) add(t *testing.T, kid string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	size := (key.Curve.Params().BitSize + 7) / 8
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = append(s.keys, jwk{
+		Kty: "EC",
+		Kid: kid,
+		Use: "sig",
+		Crv: key.Curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	})
+}
+
+func (s *testJWKS) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fetches++
+	json.NewEncoder(w).Encode(jwks{Keys: s.keys})
+}
+
+func (s *testJWKS) fetchCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fetches
+}
+
+func generateKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, e := ecdsa.GenerateKey(curve, rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return key
+}
+
+// validClaims returns claims every check accepts
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":   "alice",
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"roles": []string{"curt-users"},
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, e := token.SignedString(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return signed
+}
+
+func newTestJWTProvider(t *testing.T, url string, modify func(o *JWTOptions)) *JWTProvider {
+	t.Helper()
+	o := JWTOptions{
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		JWKSURL:      url,
+		Algorithms:   []string{"ES256", "EdDSA"},
+		Leeway:       time.Second,
+		SubjectClaim: "sub",
+		RolesClaim:   "roles",
+		ScopesClaim:  "scope",
+		RoleMap:      []string{"curt-users:editor", "curt-admins:admin"},
+		DefaultRole:  "viewer",
+	}
+	if modify != nil {
+		modify(&o)
+	}
+	p, e := NewJWTProvider(o)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func authenticate(p *JWTProvider, token string) (Principal, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return p.Authenticate(c)
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	p384 := generateKey(t, elliptic.P384())
+	server := &testJWKS{}
+	server.add(t, "k1", key)
+	server.add(t, "p384", p384)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	p := newTestJWTProvider(t, ts.URL, nil)
+
+	claims := func(modify func(c jwt.MapClaims)) jwt.MapClaims {
+		c := validClaims()
+		modify(c)
+		return c
+	}
+	unsigned, e := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		// err is the reason told in the challenge, empty when accepted
+		err string
+	}{
+		{"valid", signToken(t, jwt.SigningMethodES256, "k1", key, validClaims()), ""},
+		{"without kid", signToken(t, jwt.SigningMethodES256, "", key, validClaims()), ""},
+		{"bad signature", signToken(t, jwt.SigningMethodES256, "k1", generateKey(t, elliptic.P256()), validClaims()), "unverifiable signature"},
+		{"tampered", signToken(t, jwt.SigningMethodES256, "k1", key, validClaims()) + "A", "unverifiable signature"},
+		{"wrong issuer", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })), "wrong issuer"},
+		{"wrong audience", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { c["aud"] = "other" })), "wrong audience"},
+		{"expired", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), "expired"},
+		{"expired within the leeway", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Unix() })), ""},
+		{"without exp", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { delete(c, "exp") })), "missing exp"},
+		{"not valid yet", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Hour).Unix() })), "not valid yet"},
+		{"alg none", unsigned, "unverifiable signature"},
+		{"alg not allowed", signToken(t, jwt.SigningMethodES384, "p384", p384, validClaims()), "unverifiable signature"},
+		{"HMAC with the public key as secret", signToken(t, jwt.SigningMethodHS256, "k1", []byte(server.keys[0].X), validClaims()), "unverifiable signature"},
+		{"without subject", signToken(t, jwt.SigningMethodES256, "k1", key, claims(func(c jwt.MapClaims) { delete(c, "sub") })), "missing sub"},
+		{"malformed", "not.a.token", "malformed"},
+	}
+	for _, tt := range tests {
+		principal, e := authenticate(p, tt.token)
+		if tt.err == "" {
+			if e != nil {
+				t.Errorf("%s: rejected: %v", tt.name, e)
+			} else if principal.Subject != "alice" || principal.Method != AuthMethodJWT {
+				t.Errorf("%s: got %+v", tt.name, principal)
+			}
+			continue
+		}
+		authErr, ok := e.(*AuthError)
+		if !ok || authErr.Reason != "invalid_token" || !strings.Contains(authErr.Challenge, tt.err) {
+			t.Errorf("%s: got %v, want an invalid_token error telling %q", tt.name, e, tt.err)
+		}
+	}
+
+	if _, e := authenticate(p, ""); e != ErrNoCredentials {
+		t.Errorf("without a token: got %v, want %v", e, ErrNoCredentials)
+	}
+}
+
+func TestJWTWorkspaceClaim(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	server := &testJWKS{}
+	server.add(t, "k1", key)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	p := newTestJWTProvider(t, ts.URL, func(o *JWTOptions) { o.WorkspaceClaim = "workspace" })
+
+	for workspace, valid := range map[string]bool{"": true, "marketing": true, "a/b": false} {
+		c := validClaims()
+		if workspace != "" {
+			c["workspace"] = workspace
+		}
+		principal, e := authenticate(p, signToken(t, jwt.SigningMethodES256, "k1", key, c))
+		if valid && (e != nil || principal.Workspace != workspace) {
+			t.Errorf("workspace %q: got %+v, %v", workspace, principal, e)
+		}
+		if !valid && e == nil {
+			t.Errorf("workspace %q accepted", workspace)
+		}
+	}
+}
+
+func TestJWTUnknownKidRefresh(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	rotated := generateKey(t, elliptic.P256())
+	server := &testJWKS{}
+	server.add(t, "k1", key)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	p := newTestJWTProvider(t, ts.URL, nil)
+	if n := server.fetchCount(); n != 1 {
+		t.Fatalf("got %d fetches on start, want 1", n)
+	}
+
+	// the issuer rotates its key
+	server.add(t, "k2", rotated)
+	token := signToken(t, jwt.SigningMethodES256, "k2", rotated, validClaims())
+
+	// right after a fetch the unknown kid doesn't trigger another
+	if _, e := authenticate(p, token); e == nil {
+		t.Error("a token signed by an unknown key was accepted")
+	}
+	if n := server.fetchCount(); n != 1 {
+		t.Errorf("got %d fetches within a minute of the last, want 1", n)
+	}
+
+	// as if the last fetch was a minute ago
+	p.mutex.Lock()
+	p.fetched = time.Now().Add(-minJWKSRefresh)
+	p.mutex.Unlock()
+	if _, e := authenticate(p, token); e != nil {
+		t.Errorf("the rotated key wasn't fetched: %v", e)
+	}
+	if n := server.fetchCount(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+
+	// known kids never trigger a fetch
+	if _, e := authenticate(p, signToken(t, jwt.SigningMethodES256, "k1", key, validClaims())); e != nil {
+		t.Error(e)
+	}
+	// nor unknown ones once more within the minute
+	if _, e := authenticate(p, signToken(t, jwt.SigningMethodES256, "k3", rotated, validClaims())); e == nil {
+		t.Error("a token with an unknown kid was accepted")
+	}
+	if n := server.fetchCount(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+func TestJWTRoles(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	server := &testJWKS{}
+	server.add(t, "k1", key)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	mapped := newTestJWTProvider(t, ts.URL, nil)
+	names := newTestJWTProvider(t, ts.URL, func(o *JWTOptions) { o.RoleNames = true })
+	noDefault := newTestJWTProvider(t, ts.URL, func(o *JWTOptions) { o.DefaultRole = "" })
+
+	tests := []struct {
+		name   string
+		p      *JWTProvider
+		values interface{}
+		roles  []rbac.Role
+	}{
+		{"mapped", mapped, []string{"curt-admins"}, []rbac.Role{rbac.Admin}},
+		{"several mapped", mapped, []string{"curt-users", "curt-admins"}, []rbac.Role{rbac.Editor, rbac.Admin}},
+		{"space separated", mapped, "curt-users other", []rbac.Role{rbac.Editor}},
+		{"role name ignored", mapped, []string{"admin"}, []rbac.Role{rbac.Viewer}},
+		{"role name allowed", names, []string{"admin"}, []rbac.Role{rbac.Admin}},
+		{"map still applies", names, []string{"curt-users"}, []rbac.Role{rbac.Editor}},
+		{"unknown", names, []string{"owner"}, []rbac.Role{rbac.Viewer}},
+		{"missing claim", mapped, nil, []rbac.Role{rbac.Viewer}},
+		{"without default role", noDefault, []string{"other"}, []rbac.Role{}},
+	}
+	for _, tt := range tests {
+		c := validClaims()
+		delete(c, "roles")
+		if tt.values != nil {
+			c["roles"] = tt.values
+		}
+		principal, e := authenticate(tt.p, signToken(t, jwt.SigningMethodES256, "k1", key, c))
+		if e != nil {
+			t.Errorf("%s: %v", tt.name, e)
+			continue
+		}
+		if len(principal.Roles) != len(tt.roles) {
+			t.Errorf("%s: got roles %v, want %v", tt.name, principal.Roles, tt.roles)
+			continue
+		}
+		for i := range tt.roles {
+			if principal.Roles[i] != tt.roles[i] {
+				t.Errorf("%s: got roles %v, want %v", tt.name, principal.Roles, tt.roles)
+			}
+		}
+	}
+}
+
+func TestJWTOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *JWTOptions)
+	}{
+		{"URL and file", func(o *JWTOptions) { o.JWKSFile = "keys.pem" }},
+		{"no algorithm", func(o *JWTOptions) { o.Algorithms = nil }},
+		{"HMAC", func(o *JWTOptions) { o.Algorithms = []string{"HS256"} }},
+		{"none", func(o *JWTOptions) { o.Algorithms = []string{"none"} }},
+		{"no subject claim", func(o *JWTOptions) { o.SubjectClaim = "" }},
+		{"negative leeway", func(o *JWTOptions) { o.Leeway = -time.Second }},
+		{"invalid default role", func(o *JWTOptions) { o.DefaultRole = "owner" }},
+		{"invalid role map", func(o *JWTOptions) { o.RoleMap = []string{"group"} }},
+		{"invalid mapped role", func(o *JWTOptions) { o.RoleMap = []string{"group:owner"} }},
+	}
+	for _, tt := range tests {
+		o := JWTOptions{JWKSURL: "http://127.0.0.1:1/jwks", Algorithms: []string{"ES256"}, SubjectClaim: "sub"}
+		tt.modify(&o)
+		if _, e := NewJWTProvider(o); e == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
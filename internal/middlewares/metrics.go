@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal/metrics"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+func GinMetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		c.Next()
+
+		// the route template keeps the label cardinality bounded, unlike the path
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		metrics.Requests.WithLabelValues(route, c.Request.Method, status).Inc()
+		metrics.RequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(now).Seconds())
+	}
+}
+
+// GinMetricsAuthMiddleware requires an Authorization: Bearer header matching token,
+// an empty token lets every request through
+func GinMetricsAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(token) == 0 {
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.JSON(http.StatusUnauthorized,
+				models.GenericError{
+					Message: "wrong metrics token",
+				})
+			c.Abort()
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers the client IP can be read from
+const (
+	HeaderXForwardedFor  = "X-Forwarded-For"
+	HeaderXRealIP        = "X-Real-IP"
+	HeaderForwarded      = "Forwarded"
+	HeaderCFConnectingIP = "CF-Connecting-IP"
+	HeaderTrueClientIP   = "True-Client-IP"
+)
+
+var clientIPHeaders = []string{HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded, HeaderCFConnectingIP, HeaderTrueClientIP}
+
+// ParseTrustedProxies parses IPs and CIDRs, such as 10.0.0.0/8
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s, must be an IP or a CIDR", proxy)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, n, e := net.ParseCIDR(proxy)
+		if e != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s, must be an IP or a CIDR", proxy)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trusted(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNode parses an address as found in the forwarding headers, with an
+// optional port, brackets or quotes, e.g. "[2001:db8::17]:4711"
+func parseNode(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, e := net.SplitHostPort(node); e == nil {
+		node = host
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+// forwardedFor returns the for= nodes of the RFC 7239 Forwarded header values
+func forwardedFor(values []string) []string {
+	nodes := []string{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					node = v
+				}
+			}
+			// a hop without for= is unknown
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// clientIP walks the addresses in header from the nearest hop, skipping the
+// trusted proxies, and returns the first one that isn't, nil if an address
+// can't be parsed
+func clientIP(r *http.Request, header string, nets []*net.IPNet) net.IP {
+	values := r.Header.Values(header)
+	var nodes []string
+	if header == HeaderForwarded {
+		nodes = forwardedFor(values)
+	} else {
+		for _, value := range values {
+			nodes = append(nodes, strings.Split(value, ",")...)
+		}
+	}
+
+	var ip net.IP
+	for i := len(nodes) - 1; i >= 0; i-- {
+		ip = parseNode(nodes[i])
+		if ip == nil {
+			return nil
+		}
+		if !trusted(nets, ip) {
+			return ip
+		}
+	}
+	// every hop is a trusted proxy, the first one is the client
+	return ip
+}
+
+// GinClientIPMiddleware replaces the remote address of the requests coming
+// from a trusted proxy with the client IP in header, so that c.ClientIP(),
+// the logs and the rate limits see the client. It returns nil when no proxy
+// is trusted
+func GinClientIPMiddleware(nets []*net.IPNet, header string) (gin.HandlerFunc, error) {
+	valid := false
+	for _, h := range clientIPHeaders {
+		if strings.EqualFold(h, header) {
+			header, valid = h, true
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid client IP header: %s, must be one of %s", header, strings.Join(clientIPHeaders, ", "))
+	}
+
+	if len(nets) == 0 {
+		return nil, nil
+	}
+
+	return func(c *gin.Context) {
+		remote, _, e := net.SplitHostPort(c.Request.RemoteAddr)
+		if e != nil {
+			return
+		}
+		ip := net.ParseIP(remote)
+		if ip == nil || !trusted(nets, ip) {
+			return
+		}
+
+		client := clientIP(c.Request, header, nets)
+		if client == nil {
+			return
+		}
+		c.Request.RemoteAddr = net.JoinHostPort(client.String(), "0")
+	}, nil
+}
@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		trusted []string
+		err     string
+	}{
+		{nil, nil, ""},
+		{[]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, []string{"10.1.2.3", "192.168.1.1", "2001:db8::1"}, ""},
+		{[]string{"::ffff:192.168.1.1"}, []string{"192.168.1.1"}, ""},
+		{[]string{"proxy.internal"}, nil, "invalid trusted proxy"},
+		{[]string{"10.0.0.0/33"}, nil, "invalid trusted proxy"},
+	}
+	for _, tt := range tests {
+		nets, e := ParseTrustedProxies(tt.proxies)
+		if tt.err != "" {
+			if e == nil || !strings.Contains(e.Error(), tt.err) {
+				t.Errorf("%q: got %v, want an error containing %q", tt.proxies, e, tt.err)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("%q: %v", tt.proxies, e)
+			continue
+		}
+		for _, ip := range tt.trusted {
+			if !trusted(nets, net.ParseIP(ip)) {
+				t.Errorf("%q: %s isn't trusted", tt.proxies, ip)
+			}
+		}
+		if trusted(nets, net.ParseIP("203.0.113.7")) {
+			t.Errorf("%q: 203.0.113.7 is trusted", tt.proxies)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		values []string
+		nodes  []string
+	}{
+		{[]string{"for=192.0.2.60"}, []string{"192.0.2.60"}},
+		{[]string{`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711"`}, []string{"192.0.2.60", `"[2001:db8:cafe::17]:4711"`}},
+		{[]string{"for=192.0.2.60", "for=198.51.100.1"}, []string{"192.0.2.60", "198.51.100.1"}},
+		{[]string{"proto=https;by=10.0.0.1, for=198.51.100.1"}, []string{"", "198.51.100.1"}},
+		{[]string{"for=unknown"}, []string{"unknown"}},
+	}
+	for _, tt := range tests {
+		nodes := forwardedFor(tt.values)
+		if !equalStrings(nodes, tt.nodes) {
+			t.Errorf("forwardedFor(%q) = %q, want %q", tt.values, nodes, tt.nodes)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	nets, e := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8:cafe::/48"})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		values []string
+		ip     string
+	}{
+		{"single hop", HeaderXForwardedFor, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"trusted hops skipped", HeaderXForwardedFor, []string{"203.0.113.7, 10.0.0.2, 10.0.0.3"}, "203.0.113.7"},
+		{"spoofed hops before the client", HeaderXForwardedFor, []string{"1.2.3.4, 198.51.100.1, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"hops over several headers", HeaderXForwardedFor, []string{"198.51.100.1", "203.0.113.7", "10.0.0.2"}, "203.0.113.7"},
+		{"every hop trusted", HeaderXForwardedFor, []string{"10.0.0.4, 10.0.0.3, 10.0.0.2"}, "10.0.0.4"},
+		{"spaces and ports", HeaderXForwardedFor, []string{" 203.0.113.7:4711 ,  [2001:db8:cafe::1]:443 "}, "203.0.113.7"},
+		{"IPv6 client", HeaderXForwardedFor, []string{"2001:db8::17, 2001:db8:cafe::1"}, "2001:db8::17"},
+		{"malformed hop", HeaderXForwardedFor, []string{"203.0.113.7, not-an-ip"}, ""},
+		{"malformed hop behind the client", HeaderXForwardedFor, []string{"not-an-ip, 203.0.113.7"}, "203.0.113.7"},
+		{"empty hop", HeaderXForwardedFor, []string{"203.0.113.7,,10.0.0.2"}, ""},
+		{"no header", HeaderXForwardedFor, nil, ""},
+		{"real IP", HeaderXRealIP, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"forwarded", HeaderForwarded, []string{`for=198.51.100.1, for="[2001:db8::17]:4711";proto=https, for=10.0.0.2`}, "2001:db8::17"},
+		{"forwarded hop without for", HeaderForwarded, []string{"for=203.0.113.7, proto=https"}, ""},
+		{"forwarded obfuscated", HeaderForwarded, []string{"for=_hidden"}, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, v := range tt.values {
+			r.Header.Add(tt.header, v)
+		}
+		ip := clientIP(r, tt.header, nets)
+		if (ip == nil && tt.ip != "") || (ip != nil && !ip.Equal(net.ParseIP(tt.ip))) {
+			t.Errorf("%s: got %v, want %q", tt.name, ip, tt.ip)
+		}
+	}
+}
+
+func TestGinClientIPMiddleware(t *testing.T) {
+	nets, e := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, e = GinClientIPMiddleware(nets, "X-Client")
+	if e == nil || !strings.Contains(e.Error(), "invalid client IP header") {
+		t.Errorf("got %v for an unknown header, want invalid client IP header", e)
+	}
+	m, e := GinClientIPMiddleware(nil, "x-forwarded-for")
+	if m != nil || e != nil {
+		t.Errorf("got %v, %v without trusted proxies, want neither a middleware nor an error", m, e)
+	}
+
+	m, e = GinClientIPMiddleware(nets, "x-forwarded-for")
+	if e != nil {
+		t.Fatal(e)
+	}
+	g := gin.New()
+	g.Use(m)
+	g.GET("/", func(c *gin.Context) {
+		host, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+		c.String(http.StatusOK, host)
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		ip     string
+	}{
+		{"from a trusted proxy", "10.0.0.2:1234", "203.0.113.7", "203.0.113.7"},
+		{"through trusted proxies", "10.0.0.2:1234", "203.0.113.7, 10.0.0.3", "203.0.113.7"},
+		{"spoofed from a trusted proxy", "10.0.0.2:1234", "1.2.3.4, 203.0.113.7", "203.0.113.7"},
+		{"spoofed from an untrusted peer", "198.51.100.1:1234", "203.0.113.7", "198.51.100.1"},
+		{"spoofed proxy hop from an untrusted peer", "198.51.100.1:1234", "203.0.113.7, 10.0.0.3", "198.51.100.1"},
+		{"malformed from a trusted proxy", "10.0.0.2:1234", "203.0.113.7, nope", "10.0.0.2"},
+		{"no header from a trusted proxy", "10.0.0.2:1234", "", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set(HeaderXForwardedFor, tt.xff)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		if w.Body.String() != tt.ip {
+			t.Errorf("%s: got client %s, want %s", tt.name, w.Body.String(), tt.ip)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func TestBucketState(t *testing.T) {
+	p := RateLimitPolicy{Name: "test", Requests: 4, Period: 4 * time.Second}
+
+	tests := []struct {
+		tokens float64
+		left   float64
+		want   RateLimitResult
+	}{
+		{4, 3, RateLimitResult{Allowed: true, Remaining: 3, Reset: time.Second}},
+		{1, 0, RateLimitResult{Allowed: true, Remaining: 0, Reset: 4 * time.Second}},
+		{1.5, 0.5, RateLimitResult{Allowed: true, Remaining: 0, Reset: 3500 * time.Millisecond}},
+		{0.25, 0.25, RateLimitResult{Remaining: 0, Reset: 3750 * time.Millisecond, RetryAfter: 750 * time.Millisecond}},
+		{0, 0, RateLimitResult{Remaining: 0, Reset: 4 * time.Second, RetryAfter: time.Second}},
+	}
+	for _, tt := range tests {
+		left, got := bucketState(tt.tokens, p)
+		if left != tt.left || got != tt.want {
+			t.Errorf("bucketState(%v) = %v, %+v, want %v, %+v", tt.tokens, left, got, tt.left, tt.want)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreRefill(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	defer s.Close()
+	p := RateLimitPolicy{Name: "test", Requests: 2, Period: 200 * time.Millisecond}
+	ctx := context.Background()
+
+	take := func(key string) RateLimitResult {
+		t.Helper()
+		r, e := s.Take(ctx, key, p)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return r
+	}
+
+	for i := 0; i < p.Requests; i++ {
+		if r := take("a"); !r.Allowed {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	r := take("a")
+	if r.Allowed || r.RetryAfter <= 0 || r.RetryAfter > p.interval() {
+		t.Fatalf("got %+v once empty, want a rejection retrying within %s", r, p.interval())
+	}
+	if r := take("b"); !r.Allowed {
+		t.Error("the buckets of other keys are shared")
+	}
+
+	// one token every 100ms
+	time.Sleep(p.interval() + 20*time.Millisecond)
+	if r := take("a"); !r.Allowed {
+		t.Fatal("not refilled after an interval")
+	}
+	if r := take("a"); r.Allowed {
+		t.Error("refilled more than one token after an interval")
+	}
+
+	// never more than the capacity
+	time.Sleep(3 * p.Period)
+	for i := 0; i < p.Requests; i++ {
+		if r := take("a"); !r.Allowed {
+			t.Fatalf("request %d rejected after a full refill", i+1)
+		}
+	}
+	if r := take("a"); r.Allowed {
+		t.Error("refilled over the capacity")
+	}
+}
+
+func TestGinRateLimitMiddleware(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	defer s.Close()
+
+	g := gin.New()
+	g.Use(func(c *gin.Context) {
+		// stands for the auth
+		if subject := c.GetHeader("X-Subject"); subject != "" {
+			c.Set(PrincipalKey, Principal{Subject: subject, Method: c.GetHeader("X-Method")})
+		}
+	}, GinRateLimitMiddleware(s, RateLimitPolicy{Name: "test", Requests: 1, Period: time.Hour}))
+	g.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	serve := func(ip, method, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Method", method)
+		req.Header.Set("X-Subject", subject)
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		method  string
+		subject string
+		status  int
+	}{
+		{"first of the IP", "10.0.0.1", "", "", http.StatusNoContent},
+		{"again from the IP", "10.0.0.1", "", "", http.StatusTooManyRequests},
+		{"principal behind the IP", "10.0.0.1", AuthMethodAPIKey, "alice", http.StatusNoContent},
+		{"same principal from elsewhere", "10.0.0.2", AuthMethodAPIKey, "alice", http.StatusTooManyRequests},
+		{"same subject with another method", "10.0.0.2", AuthMethodJWT, "alice", http.StatusNoContent},
+		{"another principal", "10.0.0.1", AuthMethodAPIKey, "bob", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := serve(tt.ip, tt.method, tt.subject)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if w.Header().Get("RateLimit-Policy") != "1;w=3600" {
+			t.Errorf("%s: got RateLimit-Policy %q", tt.name, w.Header().Get("RateLimit-Policy"))
+		}
+		if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: rejected without Retry-After", tt.name)
+		}
+	}
+}
+
+func TestGinAuthFailureLimitMiddleware(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	defer s.Close()
+
+	g := gin.New()
+	authenticated := 0
+	g.Use(GinAuthFailureLimitMiddleware(s, RateLimitPolicy{Name: "auth", Requests: 2, Period: time.Hour}), func(c *gin.Context) {
+		// stands for the auth
+		authenticated++
+		if c.GetHeader("X-API-Key") != "right" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	g.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	serve := func(ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name          string
+		ip            string
+		key           string
+		status        int
+		authenticated bool
+	}{
+		{"successes aren't counted", "10.0.0.1", "right", http.StatusNoContent, true},
+		{"successes aren't counted again", "10.0.0.1", "right", http.StatusNoContent, true},
+		{"first failure", "10.0.0.1", "wrong", http.StatusUnauthorized, true},
+		{"second failure", "10.0.0.1", "wrong", http.StatusUnauthorized, true},
+		{"failure once empty", "10.0.0.1", "wrong", http.StatusTooManyRequests, false},
+		{"right key once empty", "10.0.0.1", "right", http.StatusTooManyRequests, false},
+		{"another IP", "10.0.0.2", "wrong", http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		before := authenticated
+		w := serve(tt.ip, tt.key)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if (authenticated > before) != tt.authenticated {
+			t.Errorf("%s: authenticated %t, want %t", tt.name, authenticated > before, tt.authenticated)
+		}
+		if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: rejected without Retry-After", tt.name)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/salvatore-081/curt/internal/metrics"
+	"github.com/salvatore-081/curt/pkg/models"
+)
+
+// GinClientCertMiddleware requires a client certificate verified by the TLS
+// listener, when required is false every request goes through
+func GinClientCertMiddleware(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !required {
+			return
+		}
+
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			metrics.AuthFailures.WithLabelValues("missing_client_certificate").Inc()
+			c.JSON(http.StatusUnauthorized,
+				models.GenericError{
+					Message: "a valid client certificate is required",
+				})
+			c.Abort()
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package rbac decides what each role may do, apart from gin and the
+// database, so that the policy can be evaluated on its own
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Role string
+
+const (
+	Viewer Role = "viewer"
+	Editor Role = "editor"
+	Admin  Role = "admin"
+)
+
+// roles are sorted by increasing privilege, each granting the permissions
+// of the previous ones
+var roles = []Role{Viewer, Editor, Admin}
+
+// ParseRole returns the role named name
+func ParseRole(name string) (Role, bool) {
+	for _, role := range roles {
+		if string(role) == name {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+type Permission string
+
+const (
+	ReadLinks        Permission = "links:read"
+	ReadStats        Permission = "stats:read"
+	CreateLinks      Permission = "links:create"
+	UpdateLinks      Permission = "links:update"
+	DeleteLinks      Permission = "links:delete"
+	ReadAudit        Permission = "audit:read"
+	ManageWorkspaces Permission = "workspaces:manage"
+	ManageDatabase   Permission = "database:manage"
+	ManageBlocklists Permission = "blocklists:manage"
+	ManageKeys       Permission = "keys:manage"
+)
+
+// Scope is how far a permission reaches
+type Scope int
+
+const (
+	None Scope = iota
+	// Own reaches the links owned by the subject
+	Own
+	Any
+)
+
+// grants are the permissions each role adds to the previous ones
+var grants = map[Role]map[Permission]Scope{
+	Viewer: {
+		ReadLinks: Any,
+		ReadStats: Any,
+	},
+	Editor: {
+		CreateLinks: Any,
+		UpdateLinks: Own,
+		DeleteLinks: Own,
+	},
+	Admin: {
+		UpdateLinks:      Any,
+		DeleteLinks:      Any,
+		ReadAudit:        Any,
+		ManageWorkspaces: Any,
+		ManageDatabase:   Any,
+		ManageBlocklists: Any,
+		ManageKeys:       Any,
+	},
+}
+
+// scope returns how far role reaches with p, through its own grants and the
+// ones of the roles below it
+func scope(role Role, p Permission) Scope {
+	s := None
+	for _, r := range roles {
+		if grant := grants[r][p]; grant > s {
+			s = grant
+		}
+		if r == role {
+			return s
+		}
+	}
+	return None
+}
+
+// Subject is who asks for a permission
+type Subject struct {
+	Name  string
+	Roles []Role
+}
+
+// Resource is what a permission is asked on, nil before it is known, as
+// when a route is matched, to tell whether the subject may reach any
+type Resource struct {
+	Owner string
+}
+
+// Decision is the outcome of a policy evaluation, Reason explains a denial
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Evaluate decides whether s is granted p on r
+func Evaluate(s Subject, p Permission, r *Resource) Decision {
+	best := None
+	for _, role := range s.Roles {
+		if grant := scope(role, p); grant > best {
+			best = grant
+		}
+	}
+
+	switch {
+	case best == None:
+		return Decision{Reason: fmt.Sprintf("missing permission %s, granted to the %s role, %s has %s", p, grantedTo(p), s.Name, describe(s.Roles))}
+	case best == Own && r != nil && r.Owner != s.Name:
+		return Decision{Reason: fmt.Sprintf("%s only reaches the links owned by %s, the admin role reaches the others", p, s.Name)}
+	default:
+		return Decision{Allowed: true}
+	}
+}
+
+// grantedTo returns the least privileged role granting p everywhere, or
+// else on the own links
+func grantedTo(p Permission) Role {
+	for _, role := range roles {
+		if scope(role, p) > None {
+			return role
+		}
+	}
+	return Admin
+}
+
+func describe(rs []Role) string {
+	if len(rs) == 0 {
+		return "no role"
+	}
+	names := make([]string, len(rs))
+	for i, r := range rs {
+		names[i] = string(r)
+	}
+	sort.Strings(names)
+	return "the " + strings.Join(names, ", ") + " role"
+}
@@ -0,0 +1,91 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+)
+
+var permissions = []Permission{
+	ReadLinks, ReadStats, CreateLinks, UpdateLinks, DeleteLinks,
+	ReadAudit, ManageWorkspaces, ManageDatabase, ManageBlocklists, ManageKeys,
+}
+
+func TestEvaluate(t *testing.T) {
+	// the scope of each role on each permission, the missing ones are None
+	want := map[Role]map[Permission]Scope{
+		Viewer: {ReadLinks: Any, ReadStats: Any},
+		Editor: {ReadLinks: Any, ReadStats: Any, CreateLinks: Any, UpdateLinks: Own, DeleteLinks: Own},
+		Admin: {
+			ReadLinks: Any, ReadStats: Any, CreateLinks: Any, UpdateLinks: Any, DeleteLinks: Any,
+			ReadAudit: Any, ManageWorkspaces: Any, ManageDatabase: Any, ManageBlocklists: Any, ManageKeys: Any,
+		},
+	}
+	own := &Resource{Owner: "key:alice"}
+	other := &Resource{Owner: "key:bob"}
+	unowned := &Resource{}
+
+	for _, role := range []Role{Viewer, Editor, Admin} {
+		s := Subject{Name: "key:alice", Roles: []Role{role}}
+		for _, p := range permissions {
+			scope := want[role][p]
+			tests := []struct {
+				name     string
+				resource *Resource
+				allowed  bool
+			}{
+				// nil before the resource is known, to tell whether any is reachable
+				{"nil resource", nil, scope > None},
+				{"own resource", own, scope > None},
+				{"someone else's resource", other, scope == Any},
+				{"unowned resource", unowned, scope == Any},
+			}
+			for _, tt := range tests {
+				d := Evaluate(s, p, tt.resource)
+				if d.Allowed != tt.allowed {
+					t.Errorf("%s %s on a %s: got %+v, want allowed %t", role, p, tt.name, d, tt.allowed)
+				}
+				if d.Allowed != (d.Reason == "") {
+					t.Errorf("%s %s on a %s: got reason %q", role, p, tt.name, d.Reason)
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateRoles(t *testing.T) {
+	other := &Resource{Owner: "jwt:bob"}
+
+	tests := []struct {
+		name       string
+		roles      []Role
+		permission Permission
+		resource   *Resource
+		allowed    bool
+		reason     string
+	}{
+		{"no role", nil, ReadLinks, nil, false, "missing permission links:read, granted to the viewer role, jwt:alice has no role"},
+		{"unknown role", []Role{"owner"}, ReadLinks, nil, false, "jwt:alice has the owner role"},
+		{"unknown role next to a known one", []Role{"owner", Editor}, CreateLinks, nil, true, ""},
+		{"the highest role applies", []Role{Viewer, Admin, Editor}, DeleteLinks, other, true, ""},
+		{"several roles described", []Role{Viewer, "owner"}, ManageKeys, nil, false, "granted to the admin role, jwt:alice has the owner, viewer role"},
+		{"own scope explained", []Role{Editor}, UpdateLinks, other, false, "links:update only reaches the links owned by jwt:alice"},
+		{"own scope granted to the editor", []Role{Viewer}, DeleteLinks, nil, false, "granted to the editor role"},
+		{"unknown permission", []Role{Admin}, Permission("links:burn"), nil, false, "missing permission links:burn"},
+		{"case matters", []Role{"Admin"}, ReadLinks, nil, false, "has the Admin role"},
+	}
+	for _, tt := range tests {
+		d := Evaluate(Subject{Name: "jwt:alice", Roles: tt.roles}, tt.permission, tt.resource)
+		if d.Allowed != tt.allowed || !strings.Contains(d.Reason, tt.reason) {
+			t.Errorf("%s: got %+v, want allowed %t with a reason containing %q", tt.name, d, tt.allowed, tt.reason)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for name, want := range map[string]Role{"viewer": Viewer, "editor": Editor, "admin": Admin, "": "", "Admin": "", "owner": ""} {
+		role, ok := ParseRole(name)
+		if role != want || ok != (want != "") {
+			t.Errorf("ParseRole(%q) = %q, %t, want %q", name, role, ok, want)
+		}
+	}
+}
@@ -1,42 +1,174 @@
 package internal
 
 import (
-	"time"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger/v3"
-	"github.com/rs/zerolog/log"
 	"github.com/salvatore-081/curt/internal/middlewares"
 )
 
+type Options struct {
+	Host string
+	// Domains are the base URLs of the additional short domains
+	Domains    []string
+	Links      LinkOptions
+	URLs       URLOptions
+	Blocklist  BlocklistOptions
+	Workspaces WorkspaceOptions
+	Auth       middlewares.AuthProviders
+	Database   DatabaseOptions
+	GC         GCOptions
+	Backup     BackupOptions
+	Health     HealthOptions
+}
+
 type Resolver struct {
 	Host     string
-	XAPIKey  string
+	Auth     middlewares.AuthProviders
 	BadgerDB *badger.DB
+
+	domains      []Domain
+	reservedKeys map[string]bool
+	links        LinkOptions
+	urls         URLOptions
+	blocklist    blocklist
+	workspaces   WorkspaceOptions
+	quotas       map[string]int
+	health       HealthOptions
+	backupKey    []byte
+	maintenance  maintenance
+	draining     atomic.Bool
+	auditID      atomic.Uint64
+	// fingerprintKey keys the fingerprints of the secrets in the config
+	fingerprintKey []byte
+	stop           chan struct{}
+	wg             sync.WaitGroup
 }
 
-func (r *Resolver) Create(host string, xAPIKey string) (e error) {
-	r.Host = host
-	r.XAPIKey = xAPIKey
+func (r *Resolver) Create(o Options) (e error) {
+	r.Host = o.Host
+	r.Auth = o.Auth
+
+	e = o.Links.validate()
+	if e != nil {
+		return e
+	}
+
+	e = r.setDomains(o.Host, o.Domains, o.Links)
+	if e != nil {
+		return e
+	}
+	r.setReservedKeys(o.Links.ReservedKeys)
+	r.links = o.Links
 
-	r.BadgerDB, e = badger.Open(badger.DefaultOptions("./data").WithLogger(middlewares.BadgerLogger{}))
+	e = o.URLs.validate()
 	if e != nil {
 		return e
 	}
+	r.setURLOptions(o.URLs)
 
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-	go func() {
-		for range ticker.C {
-			e := r.BadgerDB.RunValueLogGC(0.5)
-			if e != nil {
-				log.Debug().Err(e).Str("service", "badgerDB").Msg("")
-			}
+	e = o.Blocklist.validate()
+	if e != nil {
+		return e
+	}
+	r.blocklist.options = o.Blocklist
+	if o.Blocklist.enabled() {
+		e = r.blocklist.load()
+		if e != nil {
+			return e
 		}
-	}()
+	}
+
+	r.quotas, e = o.Workspaces.quotas()
+	if e != nil {
+		return e
+	}
+	r.workspaces = o.Workspaces
+
+	e = o.Database.validate()
+	if e != nil {
+		return e
+	}
+
+	e = o.GC.validate()
+	if e != nil {
+		return e
+	}
+
+	e = o.Backup.validate()
+	if e != nil {
+		return e
+	}
+
+	e = o.Health.validate()
+	if e != nil {
+		return e
+	}
+
+	o.Database.log()
+
+	r.BadgerDB, e = badger.Open(o.Database.badgerOptions())
+	if e != nil {
+		return openError(e, o.Database.EncryptionKey)
+	}
+
+	e = r.migrateOwnership()
+	if e != nil {
+		r.BadgerDB.Close()
+		return e
+	}
+
+	e = r.seedAuditID()
+	if e != nil {
+		r.BadgerDB.Close()
+		return e
+	}
+
+	r.fingerprintKey, e = o.Database.fingerprintKey()
+	if e != nil {
+		r.BadgerDB.Close()
+		return fmt.Errorf("unable to load the fingerprint key: %w", e)
+	}
+
+	r.health = o.Health
+	r.backupKey = o.Database.EncryptionKey
+	r.stop = make(chan struct{})
+	r.maintenance.options = o.GC
+
+	if o.GC.Interval > 0 && !o.Database.InMemory {
+		r.wg.Add(1)
+		go r.gcScheduler()
+	}
+
+	if o.Backup.Dir != "" {
+		r.wg.Add(1)
+		go r.backupScheduler(o.Backup)
+	}
+
+	if o.Blocklist.enabled() && o.Blocklist.ReloadInterval > 0 {
+		r.wg.Add(1)
+		go r.blocklistScheduler()
+	}
 
 	return nil
 }
 
+// Drain marks the resolver as shutting down, health checks start failing
+func (r *Resolver) Drain() {
+	r.draining.Store(true)
+}
+
+func (r *Resolver) Draining() bool {
+	return r.draining.Load()
+}
+
+// Close stops the background jobs, waiting for a running GC or backup to
+// complete, and only then closes the database
 func (r *Resolver) Close() error {
+	r.Drain()
+	close(r.stop)
+	r.wg.Wait()
 	return r.BadgerDB.Close()
 }
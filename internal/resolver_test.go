@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// testOptions returns the options of an in-memory resolver, as the defaults
+// of the config give them
+func testOptions() Options {
+	return Options{
+		Host: "http://localhost:8080",
+		Links: LinkOptions{
+			MaxChainDepth: 5,
+		},
+		URLs: URLOptions{
+			Schemes:   []string{"http", "https"},
+			MaxLength: 2048,
+		},
+		Blocklist: BlocklistOptions{
+			Action: BlocklistReject,
+		},
+		Database: DatabaseOptions{
+			InMemory:         true,
+			ValueLogFileSize: 1 << 20,
+			Compression:      "none",
+		},
+		GC: GCOptions{
+			DiscardRatio: 0.5,
+		},
+		Health: HealthOptions{
+			Timeout: time.Second,
+		},
+	}
+}
+
+// newTestResolver creates a resolver with o, closed at the end of the test
+func newTestResolver(t *testing.T, o Options) *Resolver {
+	t.Helper()
+
+	r := &Resolver{}
+	e := r.Create(o)
+	if e != nil {
+		t.Fatalf("create: %s", e)
+	}
+	t.Cleanup(func() {
+		r.Close()
+	})
+	return r
+}
+
+// setLinks stores the links of the default domain, key to URL
+func setLinks(t *testing.T, r *Resolver, links map[string]string) {
+	t.Helper()
+
+	e := r.BadgerDB.Update(func(txn *badger.Txn) error {
+		for key, url := range links {
+			e := txn.Set([]byte(key), []byte(url))
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if e != nil {
+		t.Fatalf("set links: %s", e)
+	}
+}
+
+// getLink returns the URL of the link key of the default domain, empty if
+// it doesn't exist
+func getLink(t *testing.T, r *Resolver, key string) string {
+	t.Helper()
+
+	var url string
+	e := r.BadgerDB.View(func(txn *badger.Txn) error {
+		item, e := txn.Get([]byte(key))
+		if e == badger.ErrKeyNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		v, e := item.ValueCopy(nil)
+		url = string(v)
+		return e
+	})
+	if e != nil {
+		t.Fatalf("get link %s: %s", key, e)
+	}
+	return url
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "curt"
+
+type Options struct {
+	// Exporter is one of none, otlp or stdout
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector, when empty the
+	// standard OTEL_EXPORTER_OTLP_* variables are used
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// Tracer delegates to the global provider, so it can be used before Setup
+var Tracer = otel.Tracer("github.com/salvatore-081/curt")
+
+// Setup installs the global tracer provider and the W3C propagators, the
+// returned function flushes the pending spans and must be called on shutdown
+func Setup(ctx context.Context, o Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid tracing sample ratio: %g, must be between 0 and 1", o.SampleRatio)
+	}
+
+	var exporter sdktrace.SpanExporter
+	var e error
+	switch strings.ToLower(o.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if o.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(o.Endpoint))
+		}
+		if o.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, e = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, e = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s, must be one of none, otlp, stdout", o.Exporter)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// View runs fn in a read-only badger transaction wrapped in a span
+func View(ctx context.Context, db *badger.DB, fn func(txn *badger.Txn) error) error {
+	_, span := Tracer.Start(ctx, "badger.View", trace.WithAttributes(attribute.String("db.system", "badger")))
+	defer span.End()
+
+	e := db.View(fn)
+	SetError(span, e)
+	return e
+}
+
+// Update runs fn in a read-write badger transaction wrapped in a span
+func Update(ctx context.Context, db *badger.DB, fn func(txn *badger.Txn) error) error {
+	_, span := Tracer.Start(ctx, "badger.Update", trace.WithAttributes(attribute.String("db.system", "badger")))
+	defer span.End()
+
+	e := db.Update(fn)
+	SetError(span, e)
+	return e
+}
+
+// SetError records e on span, a missing key is an expected outcome and not an error
+func SetError(span trace.Span, e error) {
+	switch {
+	case e == nil:
+	case errors.Is(e, badger.ErrKeyNotFound):
+		span.SetAttributes(attribute.Bool("badger.key_not_found", true))
+	default:
+		span.RecordError(e)
+		span.SetStatus(codes.Error, e.Error())
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+type URLOptions struct {
+	// Schemes allowed in the target URLs, lowercase
+	Schemes   []string
+	MaxLength int
+	// AllowDomains, if set, are the only domains URLs may point to,
+	// DenyDomains are rejected even if allowed. Both hold hostnames such as
+	// example.com, matching only themselves, or *.example.com, matching
+	// every subdomain of example.com
+	AllowDomains []string
+	DenyDomains  []string
+}
+
+var (
+	ErrURLRequired     = errors.New("required")
+	ErrURLNotAbsolute  = errors.New("must be an absolute URL, e.g. https://example.com/page")
+	ErrURLCredentials  = errors.New("must not contain credentials")
+	ErrURLInvalidHost  = errors.New("invalid host")
+	ErrURLDomainDenied = errors.New("domain not allowed")
+	ErrURLSchemeDenied = errors.New("scheme not allowed")
+	ErrURLTooLong      = errors.New("too long")
+)
+
+const errInvalidURLDomain = "invalid URL domain: %s, must be a hostname such as example.com or *.example.com"
+
+// idnaProfile converts internationalized hostnames to their ASCII form,
+// the way browsers resolve them
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.BidiRule(), idna.StrictDomainName(false))
+
+func (o URLOptions) validate() error {
+	if len(o.Schemes) == 0 {
+		return fmt.Errorf("missing URL schemes, at least one must be allowed")
+	}
+	for _, scheme := range o.Schemes {
+		if scheme == "" || strings.ToLower(scheme) != scheme || strings.ContainsAny(scheme, ":/") {
+			return fmt.Errorf("invalid URL scheme: %s, must be lowercase and without ://, e.g. https", scheme)
+		}
+	}
+	if o.MaxLength < 1 {
+		return fmt.Errorf("invalid URL max length: %d, must be greater than 0", o.MaxLength)
+	}
+	for _, domain := range append(append([]string{}, o.AllowDomains...), o.DenyDomains...) {
+		_, e := normalizeDomainPattern(domain)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func normalizeDomainPattern(pattern string) (string, error) {
+	name := strings.TrimPrefix(pattern, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:") {
+		return "", fmt.Errorf(errInvalidURLDomain, pattern)
+	}
+	ascii, e := idnaProfile.ToASCII(name)
+	if e != nil {
+		return "", fmt.Errorf(errInvalidURLDomain, pattern)
+	}
+	return strings.TrimSuffix(pattern, name) + ascii, nil
+}
+
+func (r *Resolver) setURLOptions(o URLOptions) {
+	normalize := func(patterns []string) []string {
+		var normalized []string
+		for _, pattern := range patterns {
+			p, _ := normalizeDomainPattern(pattern)
+			normalized = append(normalized, p)
+		}
+		return normalized
+	}
+	o.AllowDomains = normalize(o.AllowDomains)
+	o.DenyDomains = normalize(o.DenyDomains)
+	r.urls = o
+}
+
+func matchDomain(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeURL validates a target URL against the URL options and returns
+// it with the scheme and the host in their canonical, lowercase ASCII form
+func (r *Resolver) NormalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrURLRequired
+	}
+	if len(raw) > r.urls.MaxLength {
+		return "", ErrURLTooLong
+	}
+
+	u, e := url.Parse(raw)
+	if e != nil || !u.IsAbs() {
+		return "", ErrURLNotAbsolute
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	allowed := false
+	for _, scheme := range r.urls.Schemes {
+		allowed = allowed || u.Scheme == scheme
+	}
+	if !allowed {
+		return "", ErrURLSchemeDenied
+	}
+
+	// URLs without a host, such as mailto:, are only subject to the scheme,
+	// web URLs always have one, https:example.com is not one
+	if u.Opaque != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return u.String(), nil
+	}
+	if u.Host == "" {
+		return "", ErrURLNotAbsolute
+	}
+
+	// https://trusted.com@evil.com reads as trusted.com but leads to evil.com
+	if u.User != nil {
+		return "", ErrURLCredentials
+	}
+
+	host := u.Hostname()
+	if net.ParseIP(host) == nil {
+		host, e = idnaProfile.ToASCII(host)
+		if e != nil || host == "" || strings.Contains(host, "..") {
+			return "", ErrURLInvalidHost
+		}
+		host = strings.TrimSuffix(host, ".")
+	}
+	switch {
+	case u.Port() != "":
+		u.Host = net.JoinHostPort(host, u.Port())
+	case strings.Contains(host, ":"):
+		// an IPv6 literal
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if matchDomain(r.urls.DenyDomains, host) || (len(r.urls.AllowDomains) > 0 && !matchDomain(r.urls.AllowDomains, host)) {
+		return "", ErrURLDomainDenied
+	}
+
+	normalized := u.String()
+	if len(normalized) > r.urls.MaxLength {
+		return "", ErrURLTooLong
+	}
+	return normalized, nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	o := testOptions()
+	o.URLs = URLOptions{
+		Schemes:      []string{"http", "https", "mailto"},
+		MaxLength:    64,
+		AllowDomains: []string{"example.com", "*.example.org", "bücher.de", "127.0.0.1"},
+		DenyDomains:  []string{"bad.example.org"},
+	}
+	r := newTestResolver(t, o)
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		err  error
+	}{
+		{"as it is", "https://example.com/a?b=c#d", "https://example.com/a?b=c#d", nil},
+		{"trimmed", "  https://example.com/a  ", "https://example.com/a", nil},
+		{"scheme and host lowercased", "HTTPS://EXAMPLE.com/Path", "https://example.com/Path", nil},
+		{"trailing dot", "https://example.com./", "https://example.com/", nil},
+		{"port kept", "https://example.com:8443/", "https://example.com:8443/", nil},
+		{"IDN to punycode", "https://bücher.de/", "https://xn--bcher-kva.de/", nil},
+		{"IPv4", "http://127.0.0.1/", "http://127.0.0.1/", nil},
+		{"wildcard subdomain", "https://a.b.example.org/", "https://a.b.example.org/", nil},
+		{"opaque scheme", "mailto:someone@example.net", "mailto:someone@example.net", nil},
+		{"empty", "   ", "", ErrURLRequired},
+		{"relative", "/path", "", ErrURLNotAbsolute},
+		{"scheme relative", "//example.com/", "", ErrURLNotAbsolute},
+		{"http without host", "https:example.com", "", ErrURLNotAbsolute},
+		{"javascript", "javascript:alert(1)", "", ErrURLSchemeDenied},
+		{"ftp", "ftp://example.com/", "", ErrURLSchemeDenied},
+		{"credentials", "https://example.com@evil.com/", "", ErrURLCredentials},
+		{"empty label", "https://a..example.com/", "", ErrURLInvalidHost},
+		{"wildcard matches no bare domain", "https://example.org/", "", ErrURLDomainDenied},
+		{"not allowed", "https://example.net/", "", ErrURLDomainDenied},
+		{"allowed but denied", "https://bad.example.org/", "", ErrURLDomainDenied},
+		{"too long", "https://example.com/" + strings.Repeat("a", 64), "", ErrURLTooLong},
+		{"too long once escaped", "https://bücher.de/" + strings.Repeat("a", 40), "", ErrURLTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, e := r.NormalizeURL(tt.raw)
+			if e != tt.err {
+				t.Fatalf("NormalizeURL(%q) error %v, want %v", tt.raw, e, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIPv6URL(t *testing.T) {
+	r := newTestResolver(t, testOptions())
+	for raw, want := range map[string]string{
+		"http://[::1]:8080/": "http://[::1]:8080/",
+		"http://[::1]/":      "http://[::1]/",
+	} {
+		got, e := r.NormalizeURL(raw)
+		if e != nil || got != want {
+			t.Errorf("NormalizeURL(%q) = %q, %v, want %q", raw, got, e, want)
+		}
+	}
+}
+
+func TestURLOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		o     URLOptions
+		valid bool
+	}{
+		{"defaults", URLOptions{Schemes: []string{"https"}, MaxLength: 1}, true},
+		{"no scheme", URLOptions{MaxLength: 1}, false},
+		{"uppercase scheme", URLOptions{Schemes: []string{"HTTPS"}, MaxLength: 1}, false},
+		{"scheme with separator", URLOptions{Schemes: []string{"https://"}, MaxLength: 1}, false},
+		{"no max length", URLOptions{Schemes: []string{"https"}}, false},
+		{"wildcard domain", URLOptions{Schemes: []string{"https"}, MaxLength: 1, AllowDomains: []string{"*.example.com"}}, true},
+		{"inner wildcard", URLOptions{Schemes: []string{"https"}, MaxLength: 1, DenyDomains: []string{"a.*.com"}}, false},
+		{"domain with path", URLOptions{Schemes: []string{"https"}, MaxLength: 1, DenyDomains: []string{"example.com/a"}}, false},
+	}
+	for _, tt := range tests {
+		e := tt.o.validate()
+		if (e == nil) != tt.valid {
+			t.Errorf("%s: validate() = %v, want valid %t", tt.name, e, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultWorkspace holds the links of the principals without a workspace,
+// and the links created before workspaces existed
+const DefaultWorkspace = "default"
+
+// ownershipKeyPrefix namespaces the owner and workspace of the links, keyed
+// by the storage key of the link, so that the links themselves keep holding
+// the bare URL
+const ownershipKeyPrefix = internalKeyPrefix + "o/"
+
+// workspaceKeyPrefix namespaces the index of the links of each workspace,
+// keyed by workspace and storage key, to list and count them without
+// going through every link
+const workspaceKeyPrefix = internalKeyPrefix + "w/"
+
+var workspacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+var (
+	ErrInvalidWorkspace = errors.New("invalid workspace: must be 1 to 64 letters, digits, dots, dashes or underscores")
+	ErrQuotaExceeded    = errors.New("workspace link quota exceeded")
+)
+
+// ValidWorkspace tells whether name can name a workspace
+func ValidWorkspace(name string) bool {
+	return workspacePattern.MatchString(name)
+}
+
+type WorkspaceOptions struct {
+	// DefaultQuota caps the links of the workspaces without a quota of their
+	// own, 0 for no cap
+	DefaultQuota int
+	// Quotas are workspace:links pairs
+	Quotas []string
+}
+
+// quotas parses the workspace:links pairs
+func (o WorkspaceOptions) quotas() (map[string]int, error) {
+	if o.DefaultQuota < 0 {
+		return nil, fmt.Errorf("invalid default workspace quota: %d, must be 0 or greater", o.DefaultQuota)
+	}
+
+	quotas := map[string]int{}
+	for _, pair := range o.Quotas {
+		name, value, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid workspace quota: %s, must be workspace:links", pair)
+		}
+		if !ValidWorkspace(name) {
+			return nil, fmt.Errorf("invalid workspace quota: %s, %w", pair, ErrInvalidWorkspace)
+		}
+		quota, e := strconv.Atoi(value)
+		if e != nil || quota < 0 {
+			return nil, fmt.Errorf("invalid workspace quota: %s, the links must be 0 or greater", pair)
+		}
+		if _, ok := quotas[name]; ok {
+			return nil, fmt.Errorf("duplicate workspace quota: %s", name)
+		}
+		quotas[name] = quota
+	}
+	return quotas, nil
+}
+
+// Ownership is who created a link and the workspace it belongs to
+type Ownership struct {
+	Owner     string `json:"owner,omitempty"`
+	Workspace string `json:"workspace"`
+}
+
+func ownershipKey(storageKey []byte) []byte {
+	return append([]byte(ownershipKeyPrefix), storageKey...)
+}
+
+func workspacePrefix(workspace string) []byte {
+	return []byte(workspaceKeyPrefix + workspace + "/")
+}
+
+func workspaceKey(workspace string, storageKey []byte) []byte {
+	return append(workspacePrefix(workspace), storageKey...)
+}
+
+// Ownership returns the ownership of the link at storageKey, the links
+// without one belong to the default workspace
+func (r *Resolver) Ownership(txn *badger.Txn, storageKey []byte) (Ownership, error) {
+	o := Ownership{Workspace: DefaultWorkspace}
+	item, e := txn.Get(ownershipKey(storageKey))
+	if e == badger.ErrKeyNotFound {
+		return o, nil
+	}
+	if e != nil {
+		return o, e
+	}
+	e = item.Value(func(v []byte) error {
+		return json.Unmarshal(v, &o)
+	})
+	return o, e
+}
+
+// SetOwnership records o as the ownership of the link at storageKey within
+// txn, expiring with it at expiresAt, 0 for never, and moves it to the
+// index of its workspace
+func (r *Resolver) SetOwnership(txn *badger.Txn, storageKey []byte, o Ownership, expiresAt uint64) error {
+	if o.Workspace == "" {
+		o.Workspace = DefaultWorkspace
+	}
+
+	previous, e := r.Ownership(txn, storageKey)
+	if e != nil {
+		return e
+	}
+	if previous.Workspace != o.Workspace {
+		e = txn.Delete(workspaceKey(previous.Workspace, storageKey))
+		if e != nil {
+			return e
+		}
+	}
+
+	v, e := json.Marshal(o)
+	if e != nil {
+		return e
+	}
+	entry := badger.NewEntry(ownershipKey(storageKey), v)
+	entry.ExpiresAt = expiresAt
+	e = txn.SetEntry(entry)
+	if e != nil {
+		return e
+	}
+
+	entry = badger.NewEntry(workspaceKey(o.Workspace, storageKey), nil)
+	entry.ExpiresAt = expiresAt
+	return txn.SetEntry(entry)
+}
+
+// DeleteOwnership removes the ownership of the link at storageKey within
+// txn, along with its index entry
+func (r *Resolver) DeleteOwnership(txn *badger.Txn, storageKey []byte) error {
+	o, e := r.Ownership(txn, storageKey)
+	if e != nil {
+		return e
+	}
+	e = txn.Delete(workspaceKey(o.Workspace, storageKey))
+	if e != nil {
+		return e
+	}
+	return txn.Delete(ownershipKey(storageKey))
+}
+
+// Quota returns the maximum number of links of workspace, 0 for no cap
+func (r *Resolver) Quota(workspace string) int {
+	if quota, ok := r.quotas[workspace]; ok {
+		return quota
+	}
+	return r.workspaces.DefaultQuota
+}
+
+// CountLinks returns the number of links of workspace, the expired ones
+// are skipped by badger along with their link
+func (r *Resolver) CountLinks(txn *badger.Txn, workspace string) int {
+	o := badger.DefaultIteratorOptions
+	o.PrefetchValues = false
+	o.Prefix = workspacePrefix(workspace)
+	it := txn.NewIterator(o)
+	defer it.Close()
+
+	n := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
+
+// CheckQuota returns ErrQuotaExceeded when workspace can't hold one more
+// link. Two concurrent transactions may both pass the check, overshooting
+// the quota by one link each
+func (r *Resolver) CheckQuota(txn *badger.Txn, workspace string) error {
+	quota := r.Quota(workspace)
+	if quota > 0 && r.CountLinks(txn, workspace) >= quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// WorkspaceLinks calls fn with the storage key of every link of workspace
+// in domain d until it returns an error
+func (r *Resolver) WorkspaceLinks(txn *badger.Txn, workspace string, d Domain, fn func(storageKey []byte) error) error {
+	prefix := workspacePrefix(workspace)
+	o := badger.DefaultIteratorOptions
+	o.PrefetchValues = false
+	o.Prefix = append(prefix, d.Prefix()...)
+	it := txn.NewIterator(o)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		storageKey := it.Item().KeyCopy(nil)[len(prefix):]
+		if !d.Owns(storageKey) {
+			continue
+		}
+		e := fn(storageKey)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Workspaces returns the number of links of every workspace holding any,
+// and of the ones with a quota
+func (r *Resolver) Workspaces(txn *badger.Txn) map[string]int {
+	counts := map[string]int{}
+	for name := range r.quotas {
+		counts[name] = 0
+	}
+
+	o := badger.DefaultIteratorOptions
+	o.PrefetchValues = false
+	o.Prefix = []byte(workspaceKeyPrefix)
+	it := txn.NewIterator(o)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		rest := it.Item().Key()[len(workspaceKeyPrefix):]
+		if i := bytes.IndexByte(rest, '/'); i > 0 {
+			counts[string(rest[:i])]++
+		}
+	}
+	return counts
+}
+
+// migrateOwnership adds the links without an ownership, created before
+// workspaces existed or restored from such a backup, to the default
+// workspace
+func (r *Resolver) migrateOwnership() error {
+	var missing [][]byte
+	var expiresAt []uint64
+	e := r.BadgerDB.View(func(txn *badger.Txn) error {
+		for _, d := range r.domains {
+			o := badger.DefaultIteratorOptions
+			o.PrefetchValues = false
+			o.Prefix = d.Prefix()
+			it := txn.NewIterator(o)
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				if !d.Owns(item.Key()) {
+					continue
+				}
+				_, e := txn.Get(ownershipKey(item.Key()))
+				if e == nil {
+					continue
+				}
+				if e != badger.ErrKeyNotFound {
+					it.Close()
+					return e
+				}
+				missing = append(missing, item.KeyCopy(nil))
+				expiresAt = append(expiresAt, item.ExpiresAt())
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if e != nil || len(missing) == 0 {
+		return e
+	}
+
+	v, e := json.Marshal(Ownership{Workspace: DefaultWorkspace})
+	if e != nil {
+		return e
+	}
+	wb := r.BadgerDB.NewWriteBatch()
+	defer wb.Cancel()
+	for i, storageKey := range missing {
+		entry := badger.NewEntry(ownershipKey(storageKey), v)
+		entry.ExpiresAt = expiresAt[i]
+		e = wb.SetEntry(entry)
+		if e != nil {
+			return e
+		}
+		entry = badger.NewEntry(workspaceKey(DefaultWorkspace, storageKey), nil)
+		entry.ExpiresAt = expiresAt[i]
+		e = wb.SetEntry(entry)
+		if e != nil {
+			return e
+		}
+	}
+	e = wb.Flush()
+	if e != nil {
+		return e
+	}
+
+	log.Info().Str("service", "workspaces").Int("links", len(missing)).Msg("links added to the default workspace")
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// timeFormat is RFC 3339 with milliseconds
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type logOptions struct {
+	Level string
+	// Format is one of console or json
+	Format string
+	// File redirects the logs from stdout to a file rotated by size
+	File           string
+	FileMaxSize    int
+	FileMaxBackups int
+	FileMaxAge     int
+	FileCompress   bool
+}
+
+// setupLogger configures the global logger, on invalid options it falls back
+// to the console and returns the error
+func setupLogger(o logOptions) error {
+	zerolog.TimeFieldFormat = timeFormat
+
+	var out io.Writer = os.Stdout
+	if o.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   o.File,
+			MaxSize:    o.FileMaxSize,
+			MaxBackups: o.FileMaxBackups,
+			MaxAge:     o.FileMaxAge,
+			Compress:   o.FileCompress,
+		}
+	}
+
+	var e error
+	switch strings.ToLower(o.Format) {
+	case "json":
+	case "console":
+		out = consoleWriter(out, o.File != "")
+	default:
+		out = consoleWriter(os.Stdout, false)
+		e = fmt.Errorf("unknown log format: %s, must be one of console, json", o.Format)
+	}
+
+	log.Logger = zerolog.New(out).With().Timestamp().Logger()
+
+	if o.Level == "" {
+		log.Info().Str("service", "CURT").Msg("missing log_level, defaulting to DEBUG")
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		return e
+	}
+
+	l, le := zerolog.ParseLevel(strings.ToLower(o.Level))
+	if le != nil {
+		log.Info().Str("service", "CURT").Err(le).Msg(fmt.Sprintf("unknown log_level: %s, defaulting to DEBUG", o.Level))
+		l = zerolog.DebugLevel
+	}
+	zerolog.SetGlobalLevel(l)
+
+	return e
+}
+
+func consoleWriter(out io.Writer, noColor bool) zerolog.ConsoleWriter {
+	w := zerolog.ConsoleWriter{Out: out, NoColor: noColor, TimeFormat: timeFormat}
+	w.FormatLevel = func(i interface{}) string {
+		return strings.ToUpper(fmt.Sprintf("|%s|", i))
+	}
+	return w
+}
@@ -1,24 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
-
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"github.com/salvatore-081/curt/docs"
 	"github.com/salvatore-081/curt/internal"
+	"github.com/salvatore-081/curt/internal/config"
 	"github.com/salvatore-081/curt/internal/controllers"
+	"github.com/salvatore-081/curt/internal/metrics"
 	"github.com/salvatore-081/curt/internal/middlewares"
+	"github.com/salvatore-081/curt/internal/tracing"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/teris-io/shortid"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // @title Curt API
@@ -33,48 +39,232 @@ import (
 // @securitydefinitions.apikey X-API-Key
 // @in header
 // @name X-API-Key
+// @securitydefinitions.apikey Bearer
+// @in header
+// @name Authorization
+// @description Bearer JWT of the configured issuer
 func main() {
-	port := flag.String("PORT", "8080", "server port")
-	logLevel := flag.String("LOG_LEVEL", "MISSING", "log level")
-	xAPIKey := flag.String("X_API_KEY", "", "X-API-Key")
-	host := flag.String("HOST", "http://localhost:8080", "host")
+	cfg, args, e := config.Load(os.Args[1:])
+	if errors.Is(e, flag.ErrHelp) {
+		return
+	}
+	if e != nil {
+		log.Fatal().Str("service", "config").Err(e).Msg("")
+	}
 
-	flag.Parse()
+	if len(args) > 0 && args[0] == "config" {
+		e = configCommand(cfg, args)
+		if e != nil {
+			log.Fatal().Str("service", "config").Err(e).Msg("")
+		}
+		return
+	}
 
-	logOutput := zerolog.ConsoleWriter{Out: os.Stdout}
-	logOutput.FormatLevel = func(i interface{}) string {
-		return strings.ToUpper(fmt.Sprintf("|%s|", i))
+	if len(args) > 0 && args[0] == "sign-token" {
+		e = signTokenCommand(cfg.JWT, args)
+		if e != nil {
+			log.Fatal().Str("service", "CURT").Err(e).Msg("")
+		}
+		return
 	}
 
-	logOutput.FormatTimestamp = func(i interface{}) string {
-		return ""
+	if len(args) > 0 && args[0] == "hash-key" {
+		e = hashKeyCommand(args)
+		if e != nil {
+			log.Fatal().Str("service", "CURT").Err(e).Msg("")
+		}
+		return
 	}
 
-	log.Logger = zerolog.New(logOutput)
+	e = setupLogger(logOptions{
+		Level:          cfg.Log.Level,
+		Format:         cfg.Log.Format,
+		File:           cfg.Log.File,
+		FileMaxSize:    cfg.Log.FileMaxSize,
+		FileMaxBackups: cfg.Log.FileMaxBackups,
+		FileMaxAge:     cfg.Log.FileMaxAge,
+		FileCompress:   cfg.Log.FileCompress,
+	})
+	if e != nil {
+		log.Fatal().Str("service", "CURT").Err(e).Msg("")
+	}
+
+	for _, name := range config.SecretFlags(os.Args[1:]) {
+		log.Warn().Str("service", "config").Msg("-" + name + " can be read by any user in the process list, set it in the environment, the config file or a file setting instead")
+	}
+
+	apiKeys := readAPIKeys(cfg)
+
+	options := internal.Options{
+		Host:    cfg.Host,
+		Domains: cfg.Domains,
+		Links: internal.LinkOptions{
+			BasePath:      cfg.BasePath,
+			RootRedirects: cfg.RootRedirects,
+			ReservedKeys:  cfg.ReservedKeys,
+			MaxChainDepth: cfg.MaxChainDepth,
+		},
+		URLs: internal.URLOptions{
+			Schemes:      cfg.URL.Schemes,
+			MaxLength:    cfg.URL.MaxLength,
+			AllowDomains: cfg.URL.AllowDomains,
+			DenyDomains:  cfg.URL.DenyDomains,
+		},
+		Blocklist: internal.BlocklistOptions{
+			HostFiles:       cfg.Blocklist.HostFiles,
+			HashPrefixFiles: cfg.Blocklist.HashPrefixFiles,
+			RegexFiles:      cfg.Blocklist.RegexFiles,
+			Action:          cfg.Blocklist.Action,
+			CheckRedirects:  cfg.Blocklist.CheckRedirects,
+			ReloadInterval:  time.Duration(cfg.Blocklist.ReloadInterval),
+		},
+		Workspaces: internal.WorkspaceOptions{
+			DefaultQuota: cfg.Workspace.DefaultQuota,
+			Quotas:       cfg.Workspace.Quotas,
+		},
+		Auth: middlewares.AuthProviders{apiKeys},
+		Database: internal.DatabaseOptions{
+			Dir:                   cfg.Database.Dir,
+			InMemory:              cfg.Database.InMemory,
+			ValueLogFileSize:      int64(cfg.Database.ValueLogFileSize),
+			SyncWrites:            cfg.Database.SyncWrites,
+			Compression:           cfg.Database.Compression,
+			BlockCacheSize:        int64(cfg.Database.BlockCacheSize),
+			IndexCacheSize:        int64(cfg.Database.IndexCacheSize),
+			NumCompactors:         cfg.Database.NumCompactors,
+			CompactL0OnClose:      cfg.Database.CompactL0OnClose,
+			EncryptionKey:         readEncryptionKey(cfg.Database.EncryptionKey, cfg.Database.EncryptionKeyFile),
+			EncryptionKeyRotation: time.Duration(cfg.Database.EncryptionKeyRotation),
+		},
+		GC: internal.GCOptions{
+			Interval:     time.Duration(cfg.GC.Interval),
+			DiscardRatio: cfg.GC.DiscardRatio,
+			Flatten:      cfg.GC.Flatten,
+		},
+		Backup: internal.BackupOptions{
+			Dir:       cfg.Backup.Dir,
+			Interval:  time.Duration(cfg.Backup.Interval),
+			Retention: cfg.Backup.Retention,
+		},
+		Health: internal.HealthOptions{
+			Timeout:     time.Duration(cfg.Health.ReadyTimeout),
+			MinFreeDisk: uint64(cfg.Health.MinFreeDisk),
+		},
+	}
+
+	if !options.Database.InMemory {
+		warnLegacyDataDir(options.Database.Dir)
+	}
+
+	if len(args) > 0 {
+		e = command(options, args)
+		if e != nil {
+			log.Fatal().Str("service", "CURT").Err(e).Msg("")
+		}
+		return
+	}
 
 	log.Info().Str("service", "CURT").Msg("starting curt")
 
-	var e error
-	var l zerolog.Level
+	jwtProvider, e := middlewares.NewJWTProvider(jwtOptions(cfg.JWT))
+	if e != nil {
+		log.Fatal().Str("service", "auth").Err(e).Msg("")
+	}
+	if jwtProvider != nil && cfg.JWT.Audience == "" {
+		log.Warn().Str("service", "auth").Msg("JWT_AUDIENCE is empty, any token of the issuer is accepted, whoever it was issued for")
+	}
+	options.Auth = append(options.Auth, jwtProvider)
 
-	if *logLevel == "MISSING" {
-		log.Info().Str("service", "CURT").Msg("missing log_level, defaulting to DEBUG")
-		l = 0
-	} else {
-		l, e = zerolog.ParseLevel(strings.ToLower(*logLevel))
+	corsMiddleware, e := middlewares.GinCORSMiddleware(middlewares.CORSOptions{
+		Enabled:          cfg.CORS.Enabled,
+		AllowOrigins:     cfg.CORS.AllowOrigins,
+		AllowMethods:     cfg.CORS.AllowMethods,
+		AllowHeaders:     cfg.CORS.AllowHeaders,
+		ExposeHeaders:    cfg.CORS.ExposeHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           time.Duration(cfg.CORS.MaxAge),
+	})
+	if e != nil {
+		log.Fatal().Str("service", "CORS").Err(e).Msg("")
+	}
+
+	tlsConf, e := tlsConfig(tlsOptions{
+		CertFile:     cfg.TLS.CertFile,
+		KeyFile:      cfg.TLS.KeyFile,
+		MinVersion:   cfg.TLS.MinVersion,
+		ClientCAFile: cfg.TLS.ClientCAFile,
+	})
+	if e != nil {
+		log.Fatal().Str("service", "TLS").Err(e).Msg("")
+	}
+	if tlsConf == nil && cfg.TLS.RedirectAddr != "" {
+		log.Fatal().Str("service", "TLS").Msg("TLS_REDIRECT_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	trustedProxies, e := middlewares.ParseTrustedProxies(cfg.Proxy.Trusted)
+	if e != nil {
+		log.Fatal().Str("service", "proxy").Err(e).Msg("")
+	}
+	clientIPMiddleware, e := middlewares.GinClientIPMiddleware(trustedProxies, cfg.Proxy.ClientIPHeader)
+	if e != nil {
+		log.Fatal().Str("service", "proxy").Err(e).Msg("")
+	}
+	var wrap func(net.Listener) net.Listener
+	if cfg.Proxy.Protocol {
+		if len(trustedProxies) == 0 {
+			log.Fatal().Str("service", "proxy").Msg("PROXY_PROTOCOL requires TRUSTED_PROXIES")
+		}
+		wrap = proxyProtocol(trustedProxies)
+	}
+
+	var rateLimitStore middlewares.RateLimitStore
+	switch {
+	case !cfg.RateLimit.Enabled:
+		log.Info().Str("service", "rate limit").Msg("rate limits disabled")
+	case cfg.RateLimit.RedisURL != "":
+		rateLimitStore, e = middlewares.NewRedisRateLimitStore(cfg.RateLimit.RedisURL)
 		if e != nil {
-			log.Info().Str("service", "CURT").Err(e).Msg(fmt.Sprintf("unknown log_level: %s, defaulting to DEBUG", *logLevel))
-			l = 0
+			log.Fatal().Str("service", "rate limit").Err(e).Msg("")
 		}
+		log.Info().Str("service", "rate limit").Msg("rate limits shared through Redis")
+	default:
+		rateLimitStore = middlewares.NewMemoryRateLimitStore()
 	}
-	zerolog.SetGlobalLevel(l)
+	rateLimit := func(name string, rate config.Rate) gin.HandlerFunc {
+		return middlewares.GinRateLimitMiddleware(rateLimitStore, middlewares.RateLimitPolicy{
+			Name:     name,
+			Requests: rate.Requests,
+			Period:   rate.Period,
+		})
+	}
+	redirectLimit := rateLimit("redirect", cfg.RateLimit.Redirect)
+	authLimit := middlewares.GinAuthFailureLimitMiddleware(rateLimitStore, middlewares.RateLimitPolicy{
+		Name:     "auth",
+		Requests: cfg.RateLimit.Auth.Requests,
+		Period:   cfg.RateLimit.Auth.Period,
+	})
 
 	var r internal.Resolver
-	e = r.Create(*host, *xAPIKey)
+	e = r.Create(options)
 	if e != nil {
 		log.Fatal().Str("service", "badgerDB").Err(e).Msg("")
 	}
-	defer r.Close()
+
+	e = r.AuditConfig(config.Settings(cfg))
+	if e != nil {
+		log.Error().Str("service", "audit").Err(e).Msg("unable to record the config changes")
+	}
+
+	flushTraces, e := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if e != nil {
+		r.Close()
+		log.Fatal().Str("service", "tracing").Err(e).Msg("")
+	}
 
 	sid, e := shortid.New(1, shortid.DefaultABC, 2342)
 	if e != nil {
@@ -85,25 +275,257 @@ func main() {
 	gin.SetMode(gin.ReleaseMode)
 
 	g := gin.New()
+	// the client IP middleware replaces the remote address, gin trusts no header
+	g.SetTrustedProxies(nil)
 
-	g.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "X-API-Key"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	if clientIPMiddleware != nil {
+		g.Use(clientIPMiddleware)
+	}
+
+	if corsMiddleware != nil {
+		g.Use(corsMiddleware)
+	} else {
+		log.Info().Str("service", "CORS").Msg("CORS disabled")
+	}
 
+	g.Use(otelgin.Middleware(tracing.ServiceName))
 	g.Use(middlewares.GinLoggerMiddleware())
+	g.Use(middlewares.GinMetricsMiddleware())
+
+	base := g.Group(cfg.BasePath)
+
+	adminAddr := cfg.AdminAddr
+	if adminAddr == "" {
+		adminAddr = ":" + cfg.Port
+	}
 
-	controllers.C(g.Group("/c"), &r)
-	controllers.Status(g.Group("/status"), &r)
+	servers := []server{{
+		Server: &http.Server{
+			Addr:      adminAddr,
+			Handler:   g,
+			TLSConfig: tlsConf,
+		},
+		wrap: wrap,
+	}}
+
+	// the address HTTP requests are redirected to, the public one if any
+	httpsAddr := adminAddr
+
+	if cfg.PublicAddr != "" {
+		// only the redirects, without CORS since browsers follow them as navigations
+		p := gin.New()
+		p.SetTrustedProxies(nil)
+		if clientIPMiddleware != nil {
+			p.Use(clientIPMiddleware)
+		}
+		p.Use(otelgin.Middleware(tracing.ServiceName))
+		p.Use(middlewares.GinLoggerMiddleware())
+		p.Use(middlewares.GinMetricsMiddleware())
+		redirects(p.Group(cfg.BasePath), &r, cfg.RootRedirects, redirectLimit)
+
+		servers = append(servers, server{
+			Server: &http.Server{
+				Addr:      cfg.PublicAddr,
+				Handler:   p,
+				TLSConfig: tlsConf,
+			},
+			wrap: wrap,
+		})
+		httpsAddr = cfg.PublicAddr
+		log.Info().Str("service", "CURT").Msg("serving redirects on " + cfg.PublicAddr)
+	} else {
+		redirects(base, &r, cfg.RootRedirects, redirectLimit)
+	}
+	// the API creating, updating and deleting the Curt(s) requires a client
+	// certificate as much as /admin does, the redirects and probes never do.
+	// The failed authentications count per client IP before the auth, the
+	// rate limits after it per principal
+	clientCert := middlewares.GinClientCertMiddleware(cfg.TLS.ClientCAFile != "")
+	auth := middlewares.GinAuthMiddleware(r.Auth)
+	// only the creations count against the create policy, reading, updating
+	// and deleting the Curt(s) share the admin one with /admin
+	adminLimit := rateLimit("admin", cfg.RateLimit.Admin)
+	api := base.Group("/c", authLimit, clientCert, auth)
+	controllers.CGet(api.Group("", adminLimit), &r)
+	controllers.CPost(api.Group("", rateLimit("create", cfg.RateLimit.Create)), &r)
+	controllers.CPut(api.Group("", adminLimit), &r)
+	controllers.CDelete(api.Group("", adminLimit), &r)
+	controllers.Status(base.Group("/status", authLimit), &r)
+	controllers.Admin(base.Group("/admin", authLimit, clientCert, auth, adminLimit), &r)
 
 	docs.SwaggerInfo.Host = r.Host
-	g.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL(*host+"/swagger/doc.json")))
+	docs.SwaggerInfo.BasePath = cfg.BasePath
+	swaggerURL := cfg.Host + cfg.BasePath + "/swagger/doc.json"
+	if cfg.PublicAddr != "" {
+		// HOST is the public URL, the API is on the admin listener serving the UI
+		docs.SwaggerInfo.Host = ""
+		swaggerURL = "doc.json"
+	}
+	base.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL(swaggerURL)))
+
+	if cfg.TLS.RedirectAddr != "" {
+		_, httpsPort, e := net.SplitHostPort(httpsAddr)
+		if e != nil {
+			log.Fatal().Str("service", "TLS").Err(e).Msg("")
+		}
+		servers = append(servers, server{
+			Server: &http.Server{
+				Addr:    cfg.TLS.RedirectAddr,
+				Handler: httpsRedirect(httpsPort),
+			},
+			wrap: wrap,
+		})
+		log.Info().Str("service", "TLS").Msg("redirecting HTTP to HTTPS on " + cfg.TLS.RedirectAddr)
+	}
+
+	metrics.Registry.MustRegister(r.Collector())
+	metricsHandler := gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	switch {
+	case cfg.Metrics.Addr != "":
+		m := gin.New()
+		m.SetTrustedProxies(nil)
+		m.GET("/metrics", middlewares.GinMetricsAuthMiddleware(cfg.Metrics.Token), metricsHandler)
+		// scraped directly, never behind the proxies
+		servers = append(servers, server{
+			Server: &http.Server{
+				Addr:    cfg.Metrics.Addr,
+				Handler: m,
+			},
+		})
+		log.Info().Str("service", "CURT").Msg("serving metrics on " + cfg.Metrics.Addr)
+	case cfg.Metrics.Token != "":
+		base.GET("/metrics", middlewares.GinMetricsAuthMiddleware(cfg.Metrics.Token), metricsHandler)
+	default:
+		log.Info().Str("service", "CURT").Msg("metrics disabled, set METRICS_ADDR or METRICS_TOKEN to expose them")
+	}
+
+	if tlsConf != nil {
+		log.Info().Str("service", "CURT").Msg("listening and serving HTTPS on " + adminAddr)
+	} else {
+		log.Info().Str("service", "CURT").Msg("listening and serving HTTP on " + adminAddr)
+	}
+
+	flush := []func(context.Context) error{flushTraces}
+	if jwtProvider != nil {
+		flush = append(flush, func(context.Context) error {
+			return jwtProvider.Close()
+		})
+	}
+	if rateLimitStore != nil {
+		flush = append(flush, func(context.Context) error {
+			return rateLimitStore.Close()
+		})
+	}
+
+	e = serve(servers, &r, shutdownOptions{
+		Delay:   time.Duration(cfg.Shutdown.Delay),
+		Timeout: time.Duration(cfg.Shutdown.Timeout),
+		Flush:   flush,
+	})
+	if e != nil {
+		log.Fatal().Str("service", "CURT").Err(e).Msg("")
+	}
+}
+
+// redirects registers the redirect route at /c/<key> and, with root, at /<key>
+func redirects(g *gin.RouterGroup, r *internal.Resolver, root bool, limit gin.HandlerFunc) {
+	controllers.CGetKey(g.Group("/c", limit), r)
+	if root {
+		controllers.CGetKey(g.Group("", limit), r)
+	}
+}
+
+// readAPIKeys returns the API keys given inline or in files, exiting if
+// X_API_KEY is given both ways
+func readAPIKeys(c config.Config) *middlewares.APIKeys {
+	if c.XAPIKey != "" && c.XAPIKeyFile != "" {
+		log.Fatal().Str("service", "CURT").Msg("X_API_KEY and X_API_KEY_FILE are mutually exclusive")
+	}
+
+	xAPIKey := c.XAPIKey
+	if c.XAPIKeyFile != "" {
+		key, e := os.ReadFile(c.XAPIKeyFile)
+		if e != nil {
+			log.Fatal().Str("service", "CURT").Err(e).Msg("unable to read X_API_KEY_FILE")
+		}
+		// the trailing newline most editors and `echo` add
+		xAPIKey = strings.TrimRight(string(key), "\r\n")
+		if xAPIKey == "" {
+			log.Fatal().Str("service", "CURT").Msg("X_API_KEY_FILE is empty")
+		}
+	}
+
+	pairs := c.APIKeys
+	if c.APIKeysFile != "" {
+		filePairs, e := middlewares.ReadAPIKeys(c.APIKeysFile)
+		if e != nil {
+			log.Fatal().Str("service", "CURT").Err(e).Msg("unable to read API_KEYS_FILE")
+		}
+		pairs = append(append([]string{}, pairs...), filePairs...)
+	}
+
+	keys, e := middlewares.ParseAPIKeys(xAPIKey, pairs)
+	if e != nil {
+		log.Fatal().Str("service", "CURT").Err(e).Msg("")
+	}
+	e = keys.AssignRoles(c.APIKeyRole, c.APIKeyRoles)
+	if e != nil {
+		log.Fatal().Str("service", "CURT").Err(e).Msg("")
+	}
+	return keys
+}
+
+func jwtOptions(c config.JWTConfig) middlewares.JWTOptions {
+	return middlewares.JWTOptions{
+		Issuer:          c.Issuer,
+		Audience:        c.Audience,
+		JWKSURL:         c.JWKSURL,
+		JWKSFile:        c.JWKSFile,
+		RefreshInterval: time.Duration(c.RefreshInterval),
+		Algorithms:      c.Algorithms,
+		Leeway:          time.Duration(c.Leeway),
+		SubjectClaim:    c.SubjectClaim,
+		RolesClaim:      c.RolesClaim,
+		ScopesClaim:     c.ScopesClaim,
+		WorkspaceClaim:  c.WorkspaceClaim,
+		RoleMap:         c.RoleMap,
+		RoleNames:       c.RoleNames,
+		DefaultRole:     c.DefaultRole,
+		RequiredScopes:  c.RequiredScopes,
+	}
+}
+
+// readEncryptionKey returns the key given inline or in a file, exiting if both are set
+func readEncryptionKey(key string, file string) []byte {
+	if key != "" && file != "" {
+		log.Fatal().Str("service", "CURT").Msg("ENCRYPTION_KEY and ENCRYPTION_KEY_FILE are mutually exclusive")
+	}
+	if file == "" {
+		return []byte(key)
+	}
+	k, e := internal.ReadEncryptionKey(file)
+	if e != nil {
+		log.Fatal().Str("service", "CURT").Err(e).Msg("unable to read ENCRYPTION_KEY_FILE")
+	}
+	return k
+}
 
-	log.Info().Str("service", "CURT").Msg("listening and serving HTTP on port " + *port)
+// legacyDataDir is where the Docker image kept the database before DATA_DIR
+// existed, relative to its working directory
+const legacyDataDir = "/app/data"
 
-	g.Run(":" + *port)
+// warnLegacyDataDir warns when the database is opened in dir while the
+// legacy data dir holds one, as after upgrading a container that mounts its
+// volume there, which would otherwise start empty without a word
+func warnLegacyDataDir(dir string) {
+	abs, e := filepath.Abs(dir)
+	if e != nil || abs == legacyDataDir {
+		return
+	}
+	_, e = os.Stat(filepath.Join(legacyDataDir, "MANIFEST"))
+	if e != nil {
+		return
+	}
+	log.Warn().Str("service", "badgerDB").Str("dir", abs).Str("legacy_dir", legacyDataDir).Msg("a database exists in the legacy data dir but DATA_DIR points elsewhere, mount the volume on DATA_DIR or set DATA_DIR=" + legacyDataDir)
 }
@@ -0,0 +1,53 @@
+package models
+
+import "encoding/json"
+
+type GC struct {
+	Rewrites       int    `json:"rewrites"`
+	ReclaimedBytes int64  `json:"reclaimedBytes"`
+	Duration       string `json:"duration"`
+}
+
+type Blocklists struct {
+	Hosts        int    `json:"hosts"`
+	HashPrefixes int    `json:"hashPrefixes"`
+	Regexps      int    `json:"regexps"`
+	LoadedAt     string `json:"loadedAt"`
+}
+
+type AuditEntry struct {
+	ID        string          `json:"id"`
+	Time      string          `json:"time"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Domain    string          `json:"domain,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	RequestID string          `json:"requestId,omitempty"`
+	ClientIP  string          `json:"clientIp,omitempty"`
+}
+
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	// Next is the before cursor of the next page, empty on the last one
+	Next string `json:"next,omitempty"`
+}
+
+type Workspace struct {
+	Name  string `json:"name"`
+	Links int    `json:"links"`
+	// Quota is the maximum number of links, 0 for no cap
+	Quota int `json:"quota"`
+}
+
+type MoveBody struct {
+	Domain string   `json:"domain,omitempty"`
+	Keys   []string `json:"keys" binding:"required,min=1"`
+}
+
+type APIKey struct {
+	Name      string `json:"name"`
+	Workspace string `json:"workspace"`
+	Role      string `json:"role"`
+}
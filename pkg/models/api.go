@@ -1,6 +1,13 @@
 package models
 
 type Body struct {
+	Url    string  `json:"url" validate:"required"`
+	TTL    *uint16 `json:"TTL,omitempty"`
+	Domain string  `json:"domain,omitempty"`
+	Key    string  `json:"key,omitempty"`
+}
+
+type UpdateBody struct {
 	Url string  `json:"url" validate:"required"`
 	TTL *uint16 `json:"TTL,omitempty"`
 }
@@ -13,8 +20,12 @@ type Curt struct {
 	Url       string  `json:"url,omitempty"`
 	Curt      string  `json:"curt,omitempty"`
 	Key       string  `json:"key"`
+	Domain    string  `json:"domain,omitempty"`
 	TTL       *uint16 `json:"TTL,omitempty"`
 	ExpiresAt *uint64 `json:"expiresAt,omitempty"`
+	Flagged   bool    `json:"flagged,omitempty"`
+	Owner     string  `json:"owner,omitempty"`
+	Workspace string  `json:"workspace,omitempty"`
 }
 
 type StatusInternalServerError struct {
@@ -26,3 +37,13 @@ type GenericError struct {
 	Message string `json:"message"`
 	Details string `json:"details,omitempty"`
 }
+
+type ValidationError struct {
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
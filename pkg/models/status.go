@@ -6,3 +6,14 @@ type Module struct {
 	Sum     string  `json:"sum,omitempty"`
 	Replace *Module `json:"replace,omitempty"`
 }
+
+type Readiness struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+type Component struct {
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+	Latency string `json:"latency,omitempty"`
+}
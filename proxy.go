@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// proxyProtocol wraps listeners to read the PROXY protocol header, v1 or
+// v2, sent by the trusted proxies, connections from anywhere else carrying
+// one are refused
+func proxyProtocol(nets []*net.IPNet) func(net.Listener) net.Listener {
+	policy := func(upstream net.Addr) (proxyproto.Policy, error) {
+		addr, ok := upstream.(*net.TCPAddr)
+		if !ok {
+			return proxyproto.REJECT, nil
+		}
+		for _, n := range nets {
+			if n.Contains(addr.IP) {
+				return proxyproto.USE, nil
+			}
+		}
+		return proxyproto.REJECT, nil
+	}
+
+	return func(l net.Listener) net.Listener {
+		return &proxyproto.Listener{
+			Listener: l,
+			Policy:   policy,
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/salvatore-081/curt/internal/middlewares"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 4711}
+	header := func(version byte) *proxyproto.Header {
+		return &proxyproto.Header{
+			Version:           version,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.TCPv4,
+			SourceAddr:        client,
+			DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		trusted string
+		header  *proxyproto.Header
+		// remote is the address the server sees, empty when it refuses
+		remote string
+	}{
+		{"v1 from a trusted proxy", "127.0.0.0/8", header(1), client.String()},
+		{"v2 from a trusted proxy", "127.0.0.0/8", header(2), client.String()},
+		{"no header from a trusted proxy", "127.0.0.0/8", nil, "127.0.0.1"},
+		{"v1 from an untrusted peer", "10.0.0.0/8", header(1), ""},
+		{"v2 from an untrusted peer", "10.0.0.0/8", header(2), ""},
+		{"no header from an untrusted peer", "10.0.0.0/8", nil, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		nets, e := middlewares.ParseTrustedProxies([]string{tt.trusted})
+		if e != nil {
+			t.Fatal(e)
+		}
+		l, e := net.Listen("tcp", "127.0.0.1:0")
+		if e != nil {
+			t.Fatal(e)
+		}
+		l = proxyProtocol(nets)(l)
+
+		go func(h *proxyproto.Header) {
+			conn, e := net.Dial("tcp", l.Addr().String())
+			if e != nil {
+				return
+			}
+			defer conn.Close()
+			if h != nil {
+				h.WriteTo(conn)
+			}
+			conn.Write([]byte("ping\n"))
+			// wait for the server to be done with the connection
+			conn.Read(make([]byte, 1))
+		}(tt.header)
+
+		conn, e := l.Accept()
+		if e != nil {
+			t.Fatal(e)
+		}
+		line, e := bufio.NewReader(conn).ReadString('\n')
+		switch {
+		case tt.remote == "" && e == nil:
+			t.Errorf("%s: read %q, want the connection refused", tt.name, line)
+		case tt.remote != "" && (e != nil || line != "ping\n"):
+			t.Errorf("%s: read %q, %v, want ping", tt.name, line, e)
+		case tt.remote != "":
+			remote := conn.RemoteAddr().String()
+			if host, _, _ := net.SplitHostPort(remote); tt.header == nil {
+				remote = host
+			}
+			if remote != tt.remote {
+				t.Errorf("%s: got remote address %s, want %s", tt.name, remote, tt.remote)
+			}
+		}
+		conn.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/salvatore-081/curt/internal"
+)
+
+type shutdownOptions struct {
+	// Delay keeps serving while reporting unhealthy, so load balancers stop
+	// sending new requests before the listeners go away
+	Delay time.Duration
+	// Timeout bounds how long in-flight requests are waited for
+	Timeout time.Duration
+	// Flush is called once the listeners are closed, before the resolver,
+	// to push out anything still queued
+	Flush []func(context.Context) error
+}
+
+// server is an http.Server and how its listener is wrapped, if at all
+type server struct {
+	*http.Server
+	wrap func(net.Listener) net.Listener
+}
+
+// listenAndServe is srv.ListenAndServe or ListenAndServeTLS, through wrap
+func (srv server) listenAndServe() error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+	if srv.wrap != nil {
+		l = srv.wrap(l)
+	}
+
+	if srv.TLSConfig != nil {
+		// the certificate comes from TLSConfig.GetCertificate
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// serve runs every server until SIGINT or SIGTERM, or until one of them
+// fails, then drains them and tears down r
+func serve(servers []server, r *internal.Resolver, o shutdownOptions) (e error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv server) {
+			e := srv.listenAndServe()
+			if !errors.Is(e, http.ErrServerClosed) {
+				errs <- e
+			}
+		}(srv)
+	}
+
+	select {
+	case e = <-errs:
+		log.Error().Str("service", "CURT").Err(e).Msg("shutting down")
+	case <-ctx.Done():
+		log.Info().Str("service", "CURT").Msg("shutting down")
+
+		// restore the default behaviour, a second signal kills the process
+		stop()
+
+		r.Drain()
+		if o.Delay > 0 {
+			log.Info().Str("service", "CURT").Str("delay", o.Delay.String()).Msg("reporting unhealthy before closing the listeners")
+			time.Sleep(o.Delay)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv server) {
+			defer wg.Done()
+			e := srv.Shutdown(shutdownCtx)
+			if errors.Is(e, context.DeadlineExceeded) {
+				log.Warn().Str("service", "CURT").Str("addr", srv.Addr).Str("timeout", o.Timeout.String()).Msg("shutdown timeout exceeded, closing the remaining connections")
+				srv.Close()
+			} else if e != nil {
+				log.Error().Str("service", "CURT").Str("addr", srv.Addr).Err(e).Msg("")
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	for _, flush := range o.Flush {
+		e := flush(shutdownCtx)
+		if e != nil {
+			log.Error().Str("service", "CURT").Err(e).Msg("flush failed")
+		}
+	}
+
+	closeErr := r.Close()
+	if e == nil {
+		e = closeErr
+	}
+	if e != nil {
+		return e
+	}
+
+	log.Info().Str("service", "CURT").Msg("shutdown completed")
+	return nil
+}
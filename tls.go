@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type tlsOptions struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is 1.2 or 1.3
+	MinVersion string
+	// ClientCAFile enables mTLS, client certificates are requested and
+	// verified against it but only required on the admin routes
+	ClientCAFile string
+}
+
+// reloadCheckInterval bounds how often the certificate files are checked
+// for changes, the check happens on the handshakes
+const reloadCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate, reloading it once the files on disk
+// change, so that renewals need no restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (c *certReloader) modTimes() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, e := os.Stat(file)
+		if e != nil {
+			return latest, e
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load() error {
+	modTime, e := c.modTimes()
+	if e != nil {
+		return e
+	}
+	cert, e := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if e != nil {
+		return e
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.checked) < reloadCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	modTime, e := c.modTimes()
+	if e != nil || modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	// a failed reload, e.g. the key is written after the certificate, keeps
+	// the current certificate and is retried on the next check
+	e = c.load()
+	if e != nil {
+		log.Error().Str("service", "TLS").Err(e).Msg("unable to reload the certificate, keeping the current one")
+		return c.cert, nil
+	}
+	log.Info().Str("service", "TLS").Str("cert_file", c.certFile).Msg("certificate reloaded")
+	return c.cert, nil
+}
+
+// tlsConfig returns nil when TLS is disabled
+func tlsConfig(o tlsOptions) (*tls.Config, error) {
+	if o.CertFile == "" && o.KeyFile == "" {
+		if o.ClientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	config := &tls.Config{}
+
+	switch o.MinVersion {
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS min version: %s, must be 1.2 or 1.3", o.MinVersion)
+	}
+
+	reloader := &certReloader{certFile: o.CertFile, keyFile: o.KeyFile, checked: time.Now()}
+	e := reloader.load()
+	if e != nil {
+		return nil, fmt.Errorf("unable to load the certificate: %w", e)
+	}
+	config.GetCertificate = reloader.GetCertificate
+
+	if o.ClientCAFile != "" {
+		pem, e := os.ReadFile(o.ClientCAFile)
+		if e != nil {
+			return nil, e
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid TLS client CA file: %s, no PEM certificate found", o.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// httpsRedirect sends every request to the same URL over HTTPS on port
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, e := net.SplitHostPort(host); e == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}